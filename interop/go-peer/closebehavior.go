@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	goyamux "github.com/libp2p/go-yamux/v5"
+)
+
+// closeActions are the ways close-behavior mode ends an echo stream. reset
+// and close-write act on the stream; the others end the whole connection.
+var closeActions = []string{"reset", "close-write", "goaway-normal", "goaway-protocol", "goaway-internal", "drop"}
+
+// goAwayCodes are the yamux GoAway error codes of the goaway-* actions.
+var goAwayCodes = map[string]uint32{"goaway-normal": 0, "goaway-protocol": 1, "goaway-internal": 2}
+
+// closeBehavior is the action close-behavior mode applies to the next echo
+// streams. It can be changed on stdin.
+type closeBehavior struct {
+	h       host.Host
+	observe time.Duration
+
+	mu         sync.Mutex
+	action     string
+	afterBytes int
+}
+
+func parseCloseAction(action string) error {
+	if !slices.Contains(closeActions, action) {
+		return fmt.Errorf("unknown close behavior %q (%s)", action, strings.Join(closeActions, ", "))
+	}
+	return nil
+}
+
+// close-behavior mode: echo like echo-server, but end every stream with
+// action once afterBytes have been echoed, then report how the remote reacts
+func runCloseBehaviorServer(port int, transport, action string, afterBytes int, observe time.Duration, cfg *PeerConfig) {
+	if err := parseCloseAction(action); err != nil {
+		fatal("usage", "Error: %v", err)
+	}
+	h, err := createHost(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	b := &closeBehavior{h: h, observe: observe, action: action, afterBytes: afterBytes}
+	h.SetStreamHandler(protocol.ID(echoProtocol), b.handle)
+	registerStdinCommand("close-behavior", b.command)
+
+	printHostInfo(h)
+	watchStdinQuit(nil)
+	waitForShutdown()
+}
+
+// command handles "close-behavior <action> [bytes]" from stdin. The new
+// action applies to streams opened from then on.
+func (b *closeBehavior) command(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: close-behavior <action> [bytes]")
+	}
+	if err := parseCloseAction(args[0]); err != nil {
+		return fmt.Errorf("close-behavior: %w", err)
+	}
+	after := 0
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("close-behavior: invalid byte count %q", args[1])
+		}
+		after = n
+	}
+	b.mu.Lock()
+	b.action, b.afterBytes = args[0], after
+	b.mu.Unlock()
+	msg := fmt.Sprintf("%s after %d bytes", args[0], after)
+	emit(Event{Type: "close_behavior", Message: msg}, "CloseBehavior: %s", msg)
+	return nil
+}
+
+func (b *closeBehavior) handle(s network.Stream) {
+	b.mu.Lock()
+	action, after := b.action, b.afterBytes
+	b.mu.Unlock()
+	p := s.Conn().RemotePeer()
+
+	// Echo up to exactly after bytes, so the action hits at a known offset.
+	buf := make([]byte, 64*1024)
+	echoed := 0
+	for echoed < after {
+		n, err := s.Read(buf[:min(len(buf), after-echoed)])
+		if n > 0 {
+			if _, werr := s.Write(buf[:n]); werr != nil {
+				fmt.Fprintf(os.Stderr, "Echo write error: %v\n", werr)
+				s.Reset()
+				return
+			}
+			echoed += n
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "Echo read error: %v\n", err)
+			}
+			emit(Event{Type: "close_action", Peer: p.String(), Bytes: echoed, Message: "none"},
+				"CloseAction: none, stream ended after %d bytes peer=%s", echoed, p)
+			s.Close()
+			return
+		}
+	}
+
+	start := time.Now()
+	report := func(what string) {
+		at := time.Since(start)
+		emit(Event{Type: "close_observed", Peer: p.String(), ElapsedMs: msec(at), Message: what},
+			"CloseObserved: +%dms %s peer=%s", at.Milliseconds(), what, p)
+	}
+	// Watch before acting: closing a connection disconnects synchronously.
+	nb := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			if c.RemotePeer() == p {
+				report("remote reconnected")
+			}
+		},
+		DisconnectedF: func(_ network.Network, c network.Conn) {
+			if c.RemotePeer() == p {
+				report("connection closed")
+			}
+		},
+	}
+	b.h.Network().Notify(nb)
+	defer b.h.Network().StopNotify(nb)
+
+	if err := b.apply(s, action); err != nil {
+		fmt.Fprintf(os.Stderr, "close-behavior %s: %v\n", action, err)
+		s.Reset()
+		return
+	}
+	emit(Event{Type: "close_action", Peer: p.String(), Bytes: echoed, Message: action},
+		"CloseAction: %s after %d bytes peer=%s", action, echoed, p)
+
+	b.observeRemote(s, action, p, start, report)
+}
+
+func (b *closeBehavior) apply(s network.Stream, action string) error {
+	switch action {
+	case "reset":
+		return s.Reset()
+	case "close-write":
+		return s.CloseWrite()
+	case "goaway-normal":
+		// A normal GoAway only refuses new streams; go-yamux sends it without
+		// closing the session, so the current stream may still finish.
+		var sess *goyamux.Session
+		if !s.Conn().As(&sess) {
+			return errors.New("GoAway needs a yamux connection")
+		}
+		return sess.GoAway()
+	case "goaway-protocol", "goaway-internal":
+		return s.Conn().CloseWithError(network.ConnErrorCode(goAwayCodes[action]))
+	case "drop":
+		// Closes the session without GoAway and the connection underneath it.
+		return s.Conn().Close()
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+// observeRemote reports for the observe window what the remote does on the
+// stream after the action; the caller reports disconnects and reconnects.
+func (b *closeBehavior) observeRemote(s network.Stream, action string, p peer.ID, start time.Time, report func(string)) {
+	done := make(chan struct{})
+	// close-write and goaway-normal leave the stream readable.
+	switch action {
+	case "close-write", "goaway-normal":
+		go func() {
+			defer close(done)
+			buf := make([]byte, 64*1024)
+			total := 0
+			for {
+				n, err := s.Read(buf)
+				total += n
+				if n > 0 && action == "goaway-normal" {
+					s.Write(buf[:n])
+				}
+				if err != nil {
+					switch {
+					case err == io.EOF:
+						report(fmt.Sprintf("remote sent %d more bytes, then closed its write side", total))
+						if action == "goaway-normal" {
+							s.Close()
+						}
+					case errors.Is(err, network.ErrReset):
+						report(fmt.Sprintf("remote sent %d more bytes, then reset the stream", total))
+					default:
+						report(fmt.Sprintf("remote sent %d more bytes, then: %v", total, err))
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	select {
+	case <-done:
+		time.Sleep(time.Until(start.Add(b.observe)))
+	case <-time.After(b.observe):
+		s.Reset()
+	}
+	connected := b.h.Network().Connectedness(p) == network.Connected
+	report(fmt.Sprintf("observation ended, connected=%t", connected))
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+)
+
+// Defaults of go-libp2p's connection manager, used for fields left unset.
+const (
+	defaultLowWater      = 160
+	defaultHighWater     = 192
+	defaultGracePeriod   = time.Minute
+	defaultSilencePeriod = 10 * time.Second
+)
+
+// ConnManagerConfig is the conn_manager section of PeerConfig. The trigger
+// fields can also be set with --trim-after, --close-peer and --close-after.
+type ConnManagerConfig struct {
+	LowWater      int          `yaml:"low_water"`
+	HighWater     int          `yaml:"high_water"`
+	GracePeriod   yamlDuration `yaml:"grace_period"`   // new connections are never trimmed within this period
+	SilencePeriod yamlDuration `yaml:"silence_period"` // how often the high water mark is checked
+
+	TrimAfter  yamlDuration `yaml:"trim_after"`  // force one trim this long after startup
+	ClosePeer  string       `yaml:"close_peer"`  // close this peer's connections whenever it connects...
+	CloseAfter yamlDuration `yaml:"close_after"` // ...this long after it connected
+}
+
+// connManagerOpts installs a connection manager built from the conn_manager
+// section. go-libp2p's own background trimming is pushed out to a silence
+// period of a day; trimmer does the periodic high water check instead so every
+// trim can be reported.
+func connManagerOpts(cfg *PeerConfig) ([]libp2p.Option, error) {
+	if cfg == nil || cfg.ConnManager == nil {
+		return nil, nil
+	}
+	c := cfg.ConnManager
+	low, high := c.LowWater, c.HighWater
+	if low == 0 {
+		low = defaultLowWater
+	}
+	if high == 0 {
+		high = defaultHighWater
+	}
+	grace := time.Duration(c.GracePeriod)
+	if grace == 0 {
+		grace = defaultGracePeriod
+	}
+	cm, err := connmgr.NewConnManager(low, high,
+		connmgr.WithGracePeriod(grace),
+		connmgr.WithSilencePeriod(24*time.Hour),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("connection manager: %w", err)
+	}
+	return []libp2p.Option{libp2p.ConnectionManager(cm)}, nil
+}
+
+// trimmer forces and reports connection manager trims on a host.
+type trimmer struct {
+	h  host.Host
+	cm *connmgr.BasicConnMgr
+	mu sync.Mutex
+}
+
+// startConnManager hooks the trim triggers up to a freshly built host: the
+// periodic high water check, the --trim-after and --close-peer triggers and
+// the "trim" and "close-peer <id>" stdin commands.
+func startConnManager(h host.Host, cfg *PeerConfig) {
+	cm, ok := h.ConnManager().(*connmgr.BasicConnMgr)
+	if !ok {
+		return
+	}
+	t := &trimmer{h: h, cm: cm}
+
+	registerStdinCommand("trim", func(args []string) error {
+		t.trim("manual")
+		return nil
+	})
+	registerStdinCommand("close-peer", func(args []string) error {
+		if len(args) != 1 {
+			return errors.New("usage: close-peer <peer-id>")
+		}
+		pid, err := peer.Decode(args[0])
+		if err != nil {
+			return fmt.Errorf("close-peer: %w", err)
+		}
+		t.closePeer(pid, "manual")
+		return nil
+	})
+
+	if cfg == nil || cfg.ConnManager == nil {
+		return
+	}
+	c := cfg.ConnManager
+
+	interval := time.Duration(c.SilencePeriod)
+	if interval == 0 {
+		interval = defaultSilencePeriod
+	}
+	go func() {
+		for range time.Tick(interval) {
+			info := cm.GetInfo()
+			if info.ConnCount >= info.HighWater {
+				t.trim("high-water")
+			}
+		}
+	}()
+
+	if c.TrimAfter > 0 {
+		time.AfterFunc(time.Duration(c.TrimAfter), func() { t.trim("scheduled") })
+	}
+
+	if c.ClosePeer != "" {
+		pid, err := peer.Decode(c.ClosePeer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "close-peer: %v\n", err)
+			return
+		}
+		h.Network().Notify(&network.NotifyBundle{
+			ConnectedF: func(_ network.Network, conn network.Conn) {
+				if conn.RemotePeer() == pid {
+					time.AfterFunc(time.Duration(c.CloseAfter), func() { t.closePeer(pid, "scheduled") })
+				}
+			},
+		})
+	}
+}
+
+// trim runs one connection manager trim and reports which peers it closed.
+func (t *trimmer) trim(reason string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before := t.h.Network().Peers()
+	conns := len(t.h.Network().Conns())
+	t.cm.TrimOpenConns(context.Background())
+
+	var closed []string
+	for _, p := range before {
+		if t.h.Network().Connectedness(p) != network.Connected {
+			closed = append(closed, p.String())
+			emit(Event{Type: "trim_closed", Peer: p.String(), Message: reason}, "TrimClosed: %s", p)
+		}
+	}
+	info := t.cm.GetInfo()
+	emit(Event{Type: "trim", Message: reason, Conns: conns, Peers: closed},
+		"Trim (%s): %d conns, low=%d high=%d, closed %d peers", reason, conns, info.LowWater, info.HighWater, len(closed))
+}
+
+// closePeer closes every connection to p.
+func (t *trimmer) closePeer(p peer.ID, reason string) {
+	if err := t.h.Network().ClosePeer(p); err != nil {
+		fmt.Fprintf(os.Stderr, "close-peer %s: %v\n", p, err)
+		return
+	}
+	emit(Event{Type: "peer_closed", Peer: p.String(), Message: reason}, "PeerClosed: %s", p)
+}
@@ -0,0 +1,527 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	relayv2client "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// daemonCommandTimeout bounds a single daemon command unless the command
+// carries its own timeout_ms.
+const daemonCommandTimeout = 30 * time.Second
+
+// daemonCommand is one line of the daemon-mode stdin protocol.
+type daemonCommand struct {
+	ID        json.RawMessage `json:"id,omitempty"`
+	Cmd       string          `json:"cmd"`
+	Target    string          `json:"target,omitempty"`   // multiaddr with /p2p/ component
+	Peer      string          `json:"peer,omitempty"`     // peer ID of an already known peer
+	Protocol  string          `json:"protocol,omitempty"` // open-stream
+	Data      string          `json:"data,omitempty"`     // open-stream, echo, publish
+	Key       string          `json:"key,omitempty"`      // dht-put, dht-get
+	Value     string          `json:"value,omitempty"`    // dht-put
+	Topic     string          `json:"topic,omitempty"`    // publish, subscribe
+	Args      []string        `json:"args,omitempty"`     // stdin commands such as trim or gater
+	TimeoutMs int             `json:"timeout_ms,omitempty"`
+}
+
+// daemonReply is written to stdout exactly once per daemonCommand. Its type
+// is always "reply" so it can share the stdout stream with Event lines.
+type daemonReply struct {
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	ID        json.RawMessage `json:"id,omitempty"`
+	Cmd       string          `json:"cmd"`
+	OK        bool            `json:"ok"`
+	Error     string          `json:"error,omitempty"`
+	Result    any             `json:"result,omitempty"`
+}
+
+// daemon executes stdin commands against a single long-lived host.
+type daemon struct {
+	h      host.Host
+	kadDHT *dht.IpfsDHT
+	ps     *pubsub.PubSub
+
+	topicsMu sync.Mutex
+	topics   map[string]*pubsub.Topic
+	subs     map[string]*pubsub.Subscription
+
+	runningMu sync.Mutex
+	running   map[string]bool // ids of commands still executing
+}
+
+// daemon mode: one persistent host driven by line-delimited JSON commands on stdin
+func runDaemon(port int, transport string, cfg *PeerConfig) {
+	h, err := createHostWithRelay(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	ctx := context.Background()
+	kadDHT, err := newServerDHT(ctx, h)
+	if err != nil {
+		fatal("dht", "DHT error: %v", err)
+	}
+	defer kadDHT.Close()
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		fatal("pubsub", "GossipSub error: %v", err)
+	}
+
+	h.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(false))
+
+	d := &daemon{
+		h:       h,
+		kadDHT:  kadDHT,
+		ps:      ps,
+		topics:  make(map[string]*pubsub.Topic),
+		subs:    make(map[string]*pubsub.Subscription),
+		running: make(map[string]bool),
+	}
+
+	printHostInfo(h)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return
+		}
+		// Plain-text lines are the stdin commands other modes accept, acting
+		// on the same host: trim, gater, yamux-stats and so on.
+		if !strings.HasPrefix(line, "{") {
+			fields := strings.Fields(line)
+			if fn := lookupStdinCommand(fields[0]); fn != nil {
+				if err := fn(fields[1:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				continue
+			}
+		}
+
+		var cmd daemonCommand
+		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
+			d.reply(daemonReply{OK: false, Error: fmt.Sprintf("invalid command: %v", err)})
+			continue
+		}
+		if cmd.Cmd == "quit" || cmd.Cmd == "exit" {
+			d.reply(daemonReply{ID: cmd.ID, Cmd: cmd.Cmd, OK: true})
+			return
+		}
+		// Commands run concurrently so a slow one doesn't hold up the rest;
+		// replies carry the id to match them up.
+		if !d.start(cmd.ID) {
+			d.reply(daemonReply{ID: cmd.ID, Cmd: cmd.Cmd, OK: false, Error: fmt.Sprintf("command %s is still running", cmd.ID)})
+			continue
+		}
+		go func() {
+			defer d.finish(cmd.ID)
+			d.reply(d.execute(cmd))
+		}()
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Stdin error: %v\n", err)
+	}
+}
+
+// start marks the command with id as running, unless one with the same id
+// still is. Commands without an id are not tracked.
+func (d *daemon) start(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+	d.runningMu.Lock()
+	defer d.runningMu.Unlock()
+	if d.running[string(id)] {
+		return false
+	}
+	d.running[string(id)] = true
+	return true
+}
+
+func (d *daemon) finish(id json.RawMessage) {
+	d.runningMu.Lock()
+	defer d.runningMu.Unlock()
+	delete(d.running, string(id))
+}
+
+func (d *daemon) reply(r daemonReply) {
+	r.Type = "reply"
+	r.Timestamp = time.Now().UTC()
+	writeJSON(r)
+}
+
+// execute runs a single command and builds its reply.
+func (d *daemon) execute(cmd daemonCommand) daemonReply {
+	timeout := daemonCommandTimeout
+	if cmd.TimeoutMs > 0 {
+		timeout = time.Duration(cmd.TimeoutMs) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var result any
+	var err error
+	switch cmd.Cmd {
+	case "connect":
+		result, err = d.connect(ctx, cmd)
+	case "disconnect":
+		result, err = d.disconnect(cmd)
+	case "open-stream":
+		result, err = d.openStream(ctx, cmd)
+	case "echo":
+		result, err = d.echo(ctx, cmd)
+	case "ping":
+		result, err = d.ping(ctx, cmd)
+	case "dht-put":
+		result, err = d.dhtPut(ctx, cmd)
+	case "dht-get":
+		result, err = d.dhtGet(ctx, cmd)
+	case "publish":
+		result, err = d.publish(ctx, cmd)
+	case "subscribe":
+		result, err = d.subscribe(cmd)
+	case "reserve":
+		result, err = d.reserve(ctx, cmd)
+	case "list-conns":
+		result = d.listConns()
+	default:
+		if fn := lookupStdinCommand(cmd.Cmd); fn != nil {
+			err = fn(cmd.Args)
+		} else {
+			err = fmt.Errorf("unknown command %q", cmd.Cmd)
+		}
+	}
+
+	reply := daemonReply{ID: cmd.ID, Cmd: cmd.Cmd, OK: err == nil, Result: result}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// resolvePeer returns the peer a command refers to. A target multiaddr is
+// dialled first; a bare peer ID must already be known to the peerstore.
+func (d *daemon) resolvePeer(ctx context.Context, cmd daemonCommand) (peer.ID, error) {
+	if cmd.Target != "" {
+		info, err := parseTarget(cmd.Target)
+		if err != nil {
+			return "", err
+		}
+		if err := d.h.Connect(ctx, *info); err != nil {
+			return "", fmt.Errorf("connect: %w", err)
+		}
+		return info.ID, nil
+	}
+	if cmd.Peer != "" {
+		return peer.Decode(cmd.Peer)
+	}
+	return "", errors.New("target or peer required")
+}
+
+// commandPeerID extracts the peer a command refers to without dialling it.
+func commandPeerID(cmd daemonCommand) (peer.ID, error) {
+	if cmd.Peer != "" {
+		return peer.Decode(cmd.Peer)
+	}
+	if cmd.Target != "" {
+		info, err := parseTarget(cmd.Target)
+		if err != nil {
+			return "", err
+		}
+		return info.ID, nil
+	}
+	return "", errors.New("target or peer required")
+}
+
+func (d *daemon) connect(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Target == "" {
+		return nil, errors.New("target required")
+	}
+	pid, err := d.resolvePeer(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"peer": pid.String()}, nil
+}
+
+func (d *daemon) disconnect(cmd daemonCommand) (any, error) {
+	pid, err := commandPeerID(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.h.Network().ClosePeer(pid); err != nil {
+		return nil, err
+	}
+	return map[string]any{"peer": pid.String()}, nil
+}
+
+// openStream opens a stream for an arbitrary protocol, writes the payload,
+// half-closes and returns whatever the remote sent back before closing.
+func (d *daemon) openStream(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Protocol == "" {
+		return nil, errors.New("protocol required")
+	}
+	pid, err := d.resolvePeer(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.roundTrip(ctx, pid, protocol.ID(cmd.Protocol), []byte(cmd.Data))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"peer": pid.String(), "sent": len(cmd.Data), "received": len(resp), "data": string(resp)}, nil
+}
+
+func (d *daemon) echo(ctx context.Context, cmd daemonCommand) (any, error) {
+	pid, err := d.resolvePeer(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := d.roundTrip(ctx, pid, protocol.ID(echoProtocol), []byte(cmd.Data))
+	if err != nil {
+		return nil, err
+	}
+	if string(resp) != cmd.Data {
+		return nil, fmt.Errorf("echo mismatch: sent %q, got %q", cmd.Data, string(resp))
+	}
+	return map[string]any{"peer": pid.String(), "data": string(resp), "rtt_ms": msec(time.Since(start))}, nil
+}
+
+// roundTrip writes data on a new stream, closes the write side and reads the
+// response until EOF. Limited (relayed) connections are allowed.
+func (d *daemon) roundTrip(ctx context.Context, pid peer.ID, proto protocol.ID, data []byte) ([]byte, error) {
+	s, err := d.h.NewStream(network.WithAllowLimitedConn(ctx, "daemon"), pid, proto)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	defer s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+	if _, err := s.Write(data); err != nil {
+		return nil, fmt.Errorf("write: %w", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("close write: %w", err)
+	}
+	resp, err := io.ReadAll(s)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return resp, nil
+}
+
+func (d *daemon) ping(ctx context.Context, cmd daemonCommand) (any, error) {
+	pid, err := d.resolvePeer(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	// The command's own deadline bounds the ping.
+	pg := &pinger{h: d.h, p: pid}
+	rtt, err := pg.ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"peer": pid.String(), "rtt_ms": msec(rtt)}, nil
+}
+
+// waitForRoutingTable refreshes the routing table when it is still empty, so
+// DHT commands issued right after a connect have someone to query.
+func (d *daemon) waitForRoutingTable(ctx context.Context) error {
+	if d.kadDHT.RoutingTable().Size() > 0 {
+		return nil
+	}
+	select {
+	case err := <-d.kadDHT.RefreshRoutingTable():
+		if err != nil {
+			return fmt.Errorf("refresh routing table: %w", err)
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if d.kadDHT.RoutingTable().Size() == 0 {
+		return errors.New("routing table is empty")
+	}
+	return nil
+}
+
+func (d *daemon) dhtPut(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Key == "" || cmd.Value == "" {
+		return nil, errors.New("key and value required")
+	}
+	if err := d.waitForRoutingTable(ctx); err != nil {
+		return nil, err
+	}
+	if err := d.kadDHT.PutValue(ctx, cmd.Key, []byte(cmd.Value)); err != nil {
+		return nil, err
+	}
+	return map[string]any{"key": cmd.Key}, nil
+}
+
+func (d *daemon) dhtGet(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Key == "" {
+		return nil, errors.New("key required")
+	}
+	if err := d.waitForRoutingTable(ctx); err != nil {
+		return nil, err
+	}
+	val, err := d.kadDHT.GetValue(ctx, cmd.Key)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"key": cmd.Key, "value": string(val), "size": len(val)}, nil
+}
+
+// topic returns the joined topic handle for name, joining it on first use.
+func (d *daemon) topic(name string) (*pubsub.Topic, error) {
+	d.topicsMu.Lock()
+	defer d.topicsMu.Unlock()
+	if t, ok := d.topics[name]; ok {
+		return t, nil
+	}
+	t, err := d.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	d.topics[name] = t
+	return t, nil
+}
+
+func (d *daemon) publish(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Topic == "" {
+		return nil, errors.New("topic required")
+	}
+	t, err := d.topic(cmd.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Publish(ctx, []byte(cmd.Data)); err != nil {
+		return nil, err
+	}
+	return map[string]any{"topic": cmd.Topic, "size": len(cmd.Data)}, nil
+}
+
+// subscribe joins a topic and reports every message from other peers as a
+// "message" Event until the daemon exits.
+func (d *daemon) subscribe(cmd daemonCommand) (any, error) {
+	if cmd.Topic == "" {
+		return nil, errors.New("topic required")
+	}
+	t, err := d.topic(cmd.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	d.topicsMu.Lock()
+	defer d.topicsMu.Unlock()
+	if _, ok := d.subs[cmd.Topic]; ok {
+		return map[string]any{"topic": cmd.Topic}, nil
+	}
+	sub, err := t.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+	d.subs[cmd.Topic] = sub
+
+	go func() {
+		for {
+			msg, err := sub.Next(context.Background())
+			if err != nil {
+				return
+			}
+			if msg.ReceivedFrom == d.h.ID() {
+				continue
+			}
+			writeJSON(Event{
+				Type:      "message",
+				Timestamp: time.Now().UTC(),
+				Peer:      msg.GetFrom().String(),
+				Topic:     cmd.Topic,
+				Message:   string(msg.Data),
+				Bytes:     len(msg.Data),
+			})
+		}
+	}()
+	return map[string]any{"topic": cmd.Topic}, nil
+}
+
+// reserve takes a circuit relay v2 reservation on the target relay and
+// listens on the resulting circuit address.
+func (d *daemon) reserve(ctx context.Context, cmd daemonCommand) (any, error) {
+	if cmd.Target == "" {
+		return nil, errors.New("target required")
+	}
+	relayInfo, err := parseTarget(cmd.Target)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.h.Connect(ctx, *relayInfo); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	rsvp, err := relayv2client.Reserve(ctx, d.h, *relayInfo)
+	if err != nil {
+		return nil, fmt.Errorf("reserve: %w", err)
+	}
+
+	relayMA, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit", relayInfo.ID))
+	if err != nil {
+		return nil, err
+	}
+	if err := d.h.Network().Listen(relayMA); err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	var circuitAddrs []string
+	for _, raddr := range relayInfo.Addrs {
+		circuitAddrs = append(circuitAddrs, fmt.Sprintf("%s/p2p/%s/p2p-circuit/p2p/%s", raddr, relayInfo.ID, d.h.ID()))
+	}
+	return map[string]any{
+		"relay":         relayInfo.ID.String(),
+		"expiration":    rsvp.Expiration,
+		"circuit_addrs": circuitAddrs,
+	}, nil
+}
+
+func (d *daemon) listConns() any {
+	conns := []map[string]any{}
+	for _, c := range d.h.Network().Conns() {
+		state := c.ConnState()
+		conns = append(conns, map[string]any{
+			"peer":        c.RemotePeer().String(),
+			"local_addr":  c.LocalMultiaddr().String(),
+			"remote_addr": c.RemoteMultiaddr().String(),
+			"direction":   c.Stat().Direction.String(),
+			"limited":     c.Stat().Limited,
+			"streams":     len(c.GetStreams()),
+			"security":    string(state.Security),
+			"muxer":       string(state.StreamMultiplexer),
+		})
+	}
+	return conns
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputJSON switches stdout from the legacy text markers to newline-delimited
+// JSON events. It is set once from --output before any mode starts.
+var outputJSON bool
+
+// stdoutMu serialises JSON lines so concurrent handlers never interleave.
+var stdoutMu sync.Mutex
+
+// Event is one line of --output=json. The field names are the contract with
+// GoProcessManager: add fields freely, but don't rename or repurpose them.
+type Event struct {
+	Type       string        `json:"type"`
+	Timestamp  time.Time     `json:"timestamp"`
+	Peer       string        `json:"peer,omitempty"`
+	Addrs      []string      `json:"addrs,omitempty"`
+	Protocol   string        `json:"protocol,omitempty"`
+	Topic      string        `json:"topic,omitempty"`
+	Key        string        `json:"key,omitempty"`
+	Message    string        `json:"message,omitempty"`
+	Bytes      int           `json:"bytes,omitempty"`
+	RTTMs      float64       `json:"rtt_ms,omitempty"`
+	ElapsedMs  float64       `json:"elapsed_ms,omitempty"`
+	DelayMs    float64       `json:"delay_ms,omitempty"`
+	Expiration time.Time     `json:"expiration,omitzero"`
+	KeyType    string        `json:"key_type,omitempty"`
+	Conns      int           `json:"conns,omitempty"`
+	Peers      []string      `json:"peers,omitempty"`
+	EarlyMuxer *bool         `json:"early_muxer,omitempty"`
+	Stats      *SessionStats `json:"stats,omitempty"`
+	Frame      *FrameTrace   `json:"frame,omitempty"`
+	Stress     *StressStats  `json:"stress,omitempty"`
+	Perf       *PerfStats    `json:"perf,omitempty"`
+	PingStats  *PingStats    `json:"ping_stats,omitempty"`
+	ErrorClass string        `json:"error_class,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// emit reports ev on stdout. In text mode the legacy marker built from format
+// and args is printed instead, so existing parsers keep working.
+func emit(ev Event, format string, args ...any) {
+	if !outputJSON {
+		fmt.Printf(format+"\n", args...)
+		return
+	}
+	ev.Timestamp = time.Now().UTC()
+	writeJSON(ev)
+}
+
+// writeJSON encodes v as a single stdout line.
+func writeJSON(v any) {
+	stdoutMu.Lock()
+	defer stdoutMu.Unlock()
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Write event error: %v\n", err)
+	}
+}
+
+// fatal prints the error to stderr, reports it as an "error" event carrying
+// class when JSON output is on, and exits with status 1.
+func fatal(class, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, msg)
+	if outputJSON {
+		writeJSON(Event{Type: "error", Timestamp: time.Now().UTC(), ErrorClass: class, Error: msg})
+	}
+	os.Exit(1)
+}
+
+// msec converts a duration to fractional milliseconds for JSON output.
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
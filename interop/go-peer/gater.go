@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// gaterStages are the connection stages a gater can reject at, in the order
+// a connection passes them.
+var gaterStages = []string{"peer-dial", "addr-dial", "accept", "secured", "upgraded"}
+
+// GaterConfig is the gater section of PeerConfig. Peers and addresses are
+// denied if they match a deny entry or an allow list is set and they match
+// none of it. Addresses are multiaddr prefixes ("/ip4/127.0.0.1"), IPs or
+// CIDRs ("10.0.0.0/8").
+type GaterConfig struct {
+	DenyPeers  []string `yaml:"deny_peers"`
+	AllowPeers []string `yaml:"allow_peers"`
+	DenyAddrs  []string `yaml:"deny_addrs"`
+	AllowAddrs []string `yaml:"allow_addrs"`
+	// DenyAll rejects every connection, e.g. together with Stage to fail all
+	// handshakes at one point.
+	DenyAll bool `yaml:"deny_all"`
+	// Stage is where rules are enforced: peer-dial, addr-dial, accept, secured
+	// or upgraded. Left empty, peer rules apply at peer-dial (outbound) and
+	// secured (inbound), address rules at addr-dial and accept.
+	Stage string `yaml:"stage"`
+}
+
+// validate checks every entry so mistakes surface at startup rather than as
+// rules that never match.
+func (c *GaterConfig) validate() error {
+	for _, id := range append(slices.Clone(c.DenyPeers), c.AllowPeers...) {
+		if _, err := peer.Decode(id); err != nil {
+			return fmt.Errorf("peer %q: %w", id, err)
+		}
+	}
+	for _, a := range append(slices.Clone(c.DenyAddrs), c.AllowAddrs...) {
+		if _, err := parseAddrRule(a); err != nil {
+			return err
+		}
+	}
+	if c.Stage != "" && !slices.Contains(gaterStages, c.Stage) {
+		return fmt.Errorf("unknown stage %q (%s)", c.Stage, strings.Join(gaterStages, ", "))
+	}
+	return nil
+}
+
+// addrRule matches remote addresses by CIDR or by multiaddr prefix.
+type addrRule struct {
+	text   string
+	ipNet  *net.IPNet
+	prefix string
+}
+
+func parseAddrRule(s string) (addrRule, error) {
+	if strings.HasPrefix(s, "/") {
+		if _, err := multiaddr.NewMultiaddr(s); err != nil {
+			return addrRule{}, fmt.Errorf("address %q: %w", s, err)
+		}
+		return addrRule{text: s, prefix: strings.TrimSuffix(s, "/")}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return addrRule{text: s, ipNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return addrRule{}, fmt.Errorf("address %q: not a multiaddr, IP or CIDR", s)
+	}
+	return addrRule{text: s, ipNet: ipNet}, nil
+}
+
+func (r addrRule) matches(a multiaddr.Multiaddr) bool {
+	if r.ipNet != nil {
+		ip, err := manet.ToIP(a)
+		return err == nil && r.ipNet.Contains(ip)
+	}
+	s := a.String()
+	return s == r.prefix || strings.HasPrefix(s, r.prefix+"/")
+}
+
+// gater is the host's ConnectionGater. Its rules start from the gater
+// section and can be changed at runtime with the "gater" stdin command.
+type gater struct {
+	mu  sync.Mutex
+	cfg GaterConfig
+}
+
+var _ connmgr.ConnectionGater = (*gater)(nil)
+
+// connectionGaterOpts installs a gater on every host, so rules can be added
+// over stdin even without a gater section.
+func connectionGaterOpts(cfg *PeerConfig) ([]libp2p.Option, error) {
+	g := &gater{}
+	if cfg != nil && cfg.Gater != nil {
+		if err := cfg.Gater.validate(); err != nil {
+			return nil, fmt.Errorf("gater: %w", err)
+		}
+		g.cfg = *cfg.Gater
+	}
+	registerStdinCommand("gater", g.command)
+	return []libp2p.Option{libp2p.ConnectionGater(g)}, nil
+}
+
+// command handles "gater <sub> [arg]" from stdin: deny-peer, allow-peer,
+// deny-addr, allow-addr, deny-all on|off, stage <stage>|default, clear.
+func (g *gater) command(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: gater deny-peer|allow-peer|deny-addr|allow-addr|deny-all|stage|clear [arg]")
+	}
+	arg := ""
+	if len(args) > 1 {
+		arg = args[1]
+	}
+
+	g.mu.Lock()
+	next := g.cfg
+	g.mu.Unlock()
+	switch args[0] {
+	case "deny-peer":
+		next.DenyPeers = append(slices.Clone(next.DenyPeers), arg)
+	case "allow-peer":
+		next.AllowPeers = append(slices.Clone(next.AllowPeers), arg)
+	case "deny-addr":
+		next.DenyAddrs = append(slices.Clone(next.DenyAddrs), arg)
+	case "allow-addr":
+		next.AllowAddrs = append(slices.Clone(next.AllowAddrs), arg)
+	case "deny-all":
+		next.DenyAll = arg != "off"
+	case "stage":
+		if arg == "default" {
+			arg = ""
+		}
+		next.Stage = arg
+	case "clear":
+		next = GaterConfig{}
+	default:
+		return fmt.Errorf("gater: unknown command %q", args[0])
+	}
+	if err := next.validate(); err != nil {
+		return fmt.Errorf("gater: %w", err)
+	}
+
+	g.mu.Lock()
+	g.cfg = next
+	g.mu.Unlock()
+	msg := strings.TrimSpace(strings.Join(args, " "))
+	emit(Event{Type: "gater_updated", Message: msg}, "GaterUpdated: %s", msg)
+	return nil
+}
+
+// check decides whether a connection may pass stage. p or addr are empty
+// when the stage doesn't know them yet.
+func (g *gater) check(stage string, dir network.Direction, p peer.ID, addr multiaddr.Multiaddr) bool {
+	g.mu.Lock()
+	c := g.cfg
+	g.mu.Unlock()
+
+	if c.Stage != "" && c.Stage != stage {
+		return true
+	}
+	checkPeer, checkAddr := p != "", addr != nil
+	if c.Stage == "" {
+		// Default stages: the first point each piece of information is known.
+		checkPeer = stage == "peer-dial" || (stage == "secured" && dir == network.DirInbound)
+		checkAddr = stage == "addr-dial" || stage == "accept"
+		if !checkPeer && !checkAddr {
+			return true
+		}
+	}
+
+	reason := ""
+	switch {
+	case c.DenyAll:
+		reason = "deny-all"
+	case checkPeer && slices.Contains(c.DenyPeers, p.String()):
+		reason = "denied peer"
+	case checkPeer && len(c.AllowPeers) > 0 && !slices.Contains(c.AllowPeers, p.String()):
+		reason = "peer not allowed"
+	case checkAddr && matchAddrRules(c.DenyAddrs, addr):
+		reason = "denied address"
+	case checkAddr && len(c.AllowAddrs) > 0 && !matchAddrRules(c.AllowAddrs, addr):
+		reason = "address not allowed"
+	default:
+		return true
+	}
+
+	ev := Event{Type: "gater_blocked", Message: stage + ": " + reason}
+	text := fmt.Sprintf("GaterBlocked: %s %s", stage, reason)
+	if p != "" {
+		ev.Peer = p.String()
+		text += " peer=" + p.String()
+	}
+	if addr != nil {
+		ev.Addrs = []string{addr.String()}
+		text += " addr=" + addr.String()
+	}
+	emit(ev, "%s", text)
+	return false
+}
+
+func matchAddrRules(rules []string, a multiaddr.Multiaddr) bool {
+	for _, s := range rules {
+		// Rules are validated before they are stored.
+		if r, err := parseAddrRule(s); err == nil && r.matches(a) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *gater) InterceptPeerDial(p peer.ID) bool {
+	return g.check("peer-dial", network.DirOutbound, p, nil)
+}
+
+func (g *gater) InterceptAddrDial(p peer.ID, a multiaddr.Multiaddr) bool {
+	return g.check("addr-dial", network.DirOutbound, p, a)
+}
+
+func (g *gater) InterceptAccept(cm network.ConnMultiaddrs) bool {
+	return g.check("accept", network.DirInbound, "", cm.RemoteMultiaddr())
+}
+
+func (g *gater) InterceptSecured(dir network.Direction, p peer.ID, cm network.ConnMultiaddrs) bool {
+	return g.check("secured", dir, p, cm.RemoteMultiaddr())
+}
+
+func (g *gater) InterceptUpgraded(c network.Conn) (bool, control.DisconnectReason) {
+	return g.check("upgraded", c.Stat().Direction, c.RemotePeer(), c.RemoteMultiaddr()), 0
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestAddrRule(t *testing.T) {
+	for _, tc := range []struct {
+		rule    string
+		addr    string
+		matches bool
+	}{
+		{"/ip4/127.0.0.1", "/ip4/127.0.0.1/tcp/4001", true},
+		{"/ip4/127.0.0.1/", "/ip4/127.0.0.1/tcp/4001", true},
+		{"/ip4/127.0.0.1/tcp/4001", "/ip4/127.0.0.1/tcp/4001", true},
+		{"/ip4/127.0.0.1", "/ip4/127.0.0.10/tcp/4001", false},
+		{"/ip4/127.0.0.1/udp/4001", "/ip4/127.0.0.1/tcp/4001", false},
+		{"127.0.0.1", "/ip4/127.0.0.1/udp/9/quic-v1", true},
+		{"127.0.0.1", "/ip4/127.0.0.2/tcp/1", false},
+		{"::1", "/ip6/::1/tcp/1", true},
+		{"::1", "/ip4/127.0.0.1/tcp/1", false},
+		{"10.0.0.0/8", "/ip4/10.20.30.40/tcp/1", true},
+		{"10.0.0.0/8", "/ip4/11.0.0.1/tcp/1", false},
+		{"fd00::/8", "/ip6/fd12::1/tcp/1", true},
+		{"10.0.0.0/8", "/dns4/example.com/tcp/1", false},
+	} {
+		r, err := parseAddrRule(tc.rule)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.rule, err)
+		}
+		if got := r.matches(multiaddr.StringCast(tc.addr)); got != tc.matches {
+			t.Errorf("%q on %s: got %v, want %v", tc.rule, tc.addr, got, tc.matches)
+		}
+	}
+
+	for _, bad := range []string{"", "localhost", "/ip4/999.0.0.1", "10.0.0.0/33", "/nope"} {
+		if _, err := parseAddrRule(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestGaterConfigValidate(t *testing.T) {
+	const id = "12D3KooWQhgJBiNa59vV2RQK7TBFoTXrxGDBV6AdthAvsr7q1g4S"
+	for _, tc := range []struct {
+		name string
+		cfg  GaterConfig
+		ok   bool
+	}{
+		{"empty", GaterConfig{}, true},
+		{"full", GaterConfig{DenyPeers: []string{id}, AllowAddrs: []string{"10.0.0.0/8", "/ip4/127.0.0.1"}, Stage: "secured"}, true},
+		{"bad peer", GaterConfig{AllowPeers: []string{"not-a-peer"}}, false},
+		{"bad addr", GaterConfig{DenyAddrs: []string{"localhost"}}, false},
+		{"bad stage", GaterConfig{Stage: "handshake"}, false},
+	} {
+		if err := tc.cfg.validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+	}
+}
+
+func TestGaterCheck(t *testing.T) {
+	a, b := peer.ID("peer-a"), peer.ID("peer-b")
+	local := multiaddr.StringCast("/ip4/127.0.0.1/tcp/1")
+	remote := multiaddr.StringCast("/ip4/192.0.2.1/tcp/1")
+	type check struct {
+		stage string
+		dir   network.Direction
+		p     peer.ID
+		addr  multiaddr.Multiaddr
+		allow bool
+	}
+	for _, tc := range []struct {
+		name   string
+		cfg    GaterConfig
+		checks []check
+	}{
+		{
+			name: "no rules",
+			checks: []check{
+				{"peer-dial", network.DirOutbound, a, nil, true},
+				{"accept", network.DirInbound, "", remote, true},
+			},
+		},
+		{
+			name: "deny peer at default stages",
+			cfg:  GaterConfig{DenyPeers: []string{a.String()}},
+			checks: []check{
+				{"peer-dial", network.DirOutbound, a, nil, false},
+				{"peer-dial", network.DirOutbound, b, nil, true},
+				{"secured", network.DirInbound, a, local, false},
+				{"secured", network.DirOutbound, a, local, true}, // already checked at peer-dial
+				{"upgraded", network.DirInbound, a, local, true},
+			},
+		},
+		{
+			name: "allow list",
+			cfg:  GaterConfig{AllowPeers: []string{a.String()}},
+			checks: []check{
+				{"secured", network.DirInbound, a, local, true},
+				{"secured", network.DirInbound, b, local, false},
+			},
+		},
+		{
+			name: "address rules",
+			cfg:  GaterConfig{DenyAddrs: []string{"192.0.2.0/24"}},
+			checks: []check{
+				{"accept", network.DirInbound, "", remote, false},
+				{"accept", network.DirInbound, "", local, true},
+				{"addr-dial", network.DirOutbound, a, remote, false},
+				{"secured", network.DirInbound, a, remote, true},
+			},
+		},
+		{
+			name: "allowed addresses",
+			cfg:  GaterConfig{AllowAddrs: []string{"/ip4/127.0.0.1"}},
+			checks: []check{
+				{"accept", network.DirInbound, "", local, true},
+				{"accept", network.DirInbound, "", remote, false},
+			},
+		},
+		{
+			name: "rules moved to one stage",
+			cfg:  GaterConfig{DenyPeers: []string{a.String()}, DenyAddrs: []string{"192.0.2.0/24"}, Stage: "upgraded"},
+			checks: []check{
+				{"peer-dial", network.DirOutbound, a, nil, true},
+				{"accept", network.DirInbound, "", remote, true},
+				{"upgraded", network.DirOutbound, a, local, false},
+				{"upgraded", network.DirInbound, b, remote, false},
+				{"upgraded", network.DirInbound, b, local, true},
+			},
+		},
+		{
+			name: "deny all at a stage",
+			cfg:  GaterConfig{DenyAll: true, Stage: "secured"},
+			checks: []check{
+				{"accept", network.DirInbound, "", local, true},
+				{"secured", network.DirInbound, b, local, false},
+			},
+		},
+	} {
+		g := &gater{cfg: tc.cfg}
+		for _, c := range tc.checks {
+			if got := g.check(c.stage, c.dir, c.p, c.addr); got != c.allow {
+				t.Errorf("%s: %s %s peer=%q addr=%v: got %v, want %v", tc.name, c.stage, c.dir, c.p, c.addr, got, c.allow)
+			}
+		}
+	}
+}
+
+func TestGaterCommand(t *testing.T) {
+	const id = "12D3KooWQhgJBiNa59vV2RQK7TBFoTXrxGDBV6AdthAvsr7q1g4S"
+	g := &gater{}
+	for _, tc := range []struct {
+		args    []string
+		wantErr bool // rejected, rules unchanged
+	}{
+		{[]string{"deny-peer", id}, false},
+		{[]string{"deny-addr", "10.0.0.0/8"}, false},
+		{[]string{"deny-addr", "not an address"}, true},
+		{[]string{"stage", "accept"}, false},
+		{[]string{"stage", "nowhere"}, true},
+		{[]string{"deny-all"}, false},
+		{[]string{"bogus"}, true},
+		{nil, true},
+	} {
+		if err := g.command(tc.args); (err != nil) != tc.wantErr {
+			t.Errorf("%q: got %v, want error %t", tc.args, err, tc.wantErr)
+		}
+	}
+	c := g.cfg
+	if len(c.DenyPeers) != 1 || len(c.DenyAddrs) != 1 || c.Stage != "accept" || !c.DenyAll {
+		t.Errorf("after commands: %+v", c)
+	}
+
+	g.command([]string{"deny-all", "off"})
+	g.command([]string{"stage", "default"})
+	if g.cfg.DenyAll || g.cfg.Stage != "" {
+		t.Errorf("after deny-all off and stage default: %+v", g.cfg)
+	}
+	g.command([]string{"clear"})
+	if len(g.cfg.DenyPeers) != 0 || len(g.cfg.DenyAddrs) != 0 {
+		t.Errorf("after clear: %+v", g.cfg)
+	}
+}
@@ -15,6 +15,7 @@ require (
 	github.com/libp2p/go-yamux/v5 v5.0.1
 	github.com/multiformats/go-multiaddr v0.16.1
 	github.com/stephanfeb/go-libp2p-udx-transport v0.0.0-00010101000000-000000000000
+	google.golang.org/protobuf v1.36.11
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -124,7 +125,6 @@ require (
 	golang.org/x/time v0.12.0 // indirect
 	golang.org/x/tools v0.41.0 // indirect
 	gonum.org/v1/gonum v0.17.0 // indirect
-	google.golang.org/protobuf v1.36.11 // indirect
 	lukechampine.com/blake3 v1.4.1 // indirect
 )
 
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// hostKey returns the private key for a new host. With a key file the key is
+// loaded from it, or created and saved there if the file doesn't exist yet.
+// With a seed the key is derived from it, so the PeerID is stable across runs.
+// Otherwise a fresh key is generated. The key type defaults to Ed25519.
+func hostKey(cfg *PeerConfig) (crypto.PrivKey, error) {
+	var keyFile, seed, keyType string
+	if cfg != nil {
+		keyFile, seed, keyType = cfg.Identity.KeyFile, cfg.Identity.Seed, cfg.Identity.KeyType
+	}
+
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err == nil {
+			priv, err := crypto.UnmarshalPrivateKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("key file %s: %w", keyFile, err)
+			}
+			if want, ok := keyTypes[keyType]; ok && priv.Type() != want {
+				return nil, fmt.Errorf("key file %s holds a %s key, not %s", keyFile, priv.Type(), keyType)
+			}
+			return priv, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("read key file: %w", err)
+		}
+	}
+
+	priv, err := generateKey(keyType, seed)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+
+	if keyFile != "" {
+		data, err := crypto.MarshalPrivateKey(priv)
+		if err != nil {
+			return nil, fmt.Errorf("marshal key: %w", err)
+		}
+		if err := os.WriteFile(keyFile, data, 0o600); err != nil {
+			return nil, fmt.Errorf("write key file: %w", err)
+		}
+	}
+	return priv, nil
+}
+
+// keyTypes maps --key-type names to libp2p key types.
+var keyTypes = map[string]pb.KeyType{
+	"ed25519":   crypto.Ed25519,
+	"rsa":       crypto.RSA,
+	"secp256k1": crypto.Secp256k1,
+	"ecdsa":     crypto.ECDSA,
+}
+
+// rsaKeyBits is the RSA modulus size, the same go-libp2p uses by default.
+const rsaKeyBits = 2048
+
+// generateKey creates a key of keyType. With a seed, the SHA-256 of the seed
+// is used as the private scalar (Ed25519 seed, secp256k1 or P-256 scalar).
+// RSA generation can't be made deterministic, so RSA rejects a seed.
+func generateKey(keyType, seed string) (crypto.PrivKey, error) {
+	if keyType == "" {
+		keyType = "ed25519"
+	}
+	typ, ok := keyTypes[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q (ed25519, rsa, secp256k1, ecdsa)", keyType)
+	}
+
+	if seed == "" {
+		priv, _, err := crypto.GenerateKeyPairWithReader(int(typ), rsaKeyBits, rand.Reader)
+		return priv, err
+	}
+
+	sum := sha256.Sum256([]byte(seed))
+	switch typ {
+	case crypto.Ed25519:
+		priv, _, err := crypto.GenerateEd25519Key(bytes.NewReader(sum[:]))
+		return priv, err
+	case crypto.Secp256k1:
+		return crypto.UnmarshalSecp256k1PrivateKey(sum[:])
+	case crypto.ECDSA:
+		return seededECDSAKey(sum[:])
+	}
+	return nil, errors.New("--seed is not supported for rsa keys; use --key-file for a stable RSA identity")
+}
+
+// seededECDSAKey turns 32 bytes into a valid P-256 scalar in [1, N-1] and
+// builds the key pair from it.
+func seededECDSAKey(b []byte) (crypto.PrivKey, error) {
+	n := new(big.Int).Sub(elliptic.P256().Params().N, big.NewInt(1))
+	d := new(big.Int).Mod(new(big.Int).SetBytes(b), n)
+	d.Add(d, big.NewInt(1))
+
+	ecdhKey, err := ecdh.P256().NewPrivateKey(d.FillBytes(make([]byte, 32)))
+	if err != nil {
+		return nil, err
+	}
+	// ecdh keys can't be converted to ecdsa directly; PKCS#8 round-trips them.
+	der, err := x509.MarshalPKCS8PrivateKey(ecdhKey)
+	if err != nil {
+		return nil, err
+	}
+	stdKey, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	priv, _, err := crypto.KeyPairFromStdKey(stdKey)
+	return priv, err
+}
+
+// keyTypeName returns the --key-type name of the host's own key.
+func keyTypeName(h host.Host) string {
+	typ := h.Peerstore().PubKey(h.ID()).Type()
+	for name, t := range keyTypes {
+		if t == typ {
+			return name
+		}
+	}
+	return typ.String()
+}
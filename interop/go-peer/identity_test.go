@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestHostKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "peer.key")
+	// Cases run in order: the key file cases start without the file.
+	for _, tc := range []struct {
+		name                   string
+		keyFile, seed, keyType string
+		want                   pb.KeyType
+		wantErr                bool
+		stable                 bool // a second call gives the same PeerID
+	}{
+		{name: "seeded", seed: "alice", want: crypto.Ed25519, stable: true},
+		{name: "seeded secp256k1", seed: "alice", keyType: "secp256k1", want: crypto.Secp256k1, stable: true},
+		{name: "seeded ecdsa", seed: "alice", keyType: "ecdsa", want: crypto.ECDSA, stable: true},
+		{name: "seeded rsa", seed: "alice", keyType: "rsa", wantErr: true},
+		{name: "unknown type", keyType: "dsa", wantErr: true},
+		{name: "random", want: crypto.Ed25519},
+		{name: "random ecdsa", keyType: "ecdsa", want: crypto.ECDSA},
+		{name: "new key file", keyFile: keyFile, want: crypto.Ed25519, stable: true},
+		{name: "key file, same type", keyFile: keyFile, keyType: "ed25519", want: crypto.Ed25519, stable: true},
+		{name: "key file, other type", keyFile: keyFile, keyType: "secp256k1", wantErr: true},
+	} {
+		cfg := &PeerConfig{}
+		cfg.Identity.KeyFile, cfg.Identity.Seed, cfg.Identity.KeyType = tc.keyFile, tc.seed, tc.keyType
+		priv, err := hostKey(cfg)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: got a %s key, want an error", tc.name, priv.Type())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if priv.Type() != tc.want {
+			t.Errorf("%s: got a %s key, want %s", tc.name, priv.Type(), tc.want)
+		}
+
+		again, err := hostKey(cfg)
+		if err != nil {
+			t.Errorf("%s, second call: %v", tc.name, err)
+			continue
+		}
+		id, _ := peer.IDFromPrivateKey(priv)
+		id2, _ := peer.IDFromPrivateKey(again)
+		if (id == id2) != tc.stable {
+			t.Errorf("%s: PeerIDs %s and %s, want stable %t", tc.name, id, id2, tc.stable)
+		}
+	}
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"crypto/rand"
 	"flag"
 	"fmt"
 	"io"
@@ -11,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -21,13 +20,9 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
-	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	yamux "github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	goyamux "github.com/libp2p/go-yamux/v5"
-	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
 	relayv2client "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
-	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
-	udxtransport "github.com/stephanfeb/go-libp2p-udx-transport"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/ipfs/go-cid"
@@ -40,9 +35,42 @@ const echoProtocol = "/echo/1.0.0"
 // PeerConfig holds YAML-configurable settings for the Go peer.
 type PeerConfig struct {
 	Yamux struct {
-		KeepaliveInterval      int `yaml:"keepalive_interval"`       // seconds
-		ConnectionWriteTimeout int `yaml:"connection_write_timeout"` // seconds
+		KeepaliveInterval      int          `yaml:"keepalive_interval"`       // seconds
+		ConnectionWriteTimeout int          `yaml:"connection_write_timeout"` // seconds
+		InitialStreamWindow    uint32       `yaml:"initial_stream_window"`    // bytes, at least 256 KiB
+		MaxStreamWindow        uint32       `yaml:"max_stream_window"`        // bytes
+		AcceptBacklog          int          `yaml:"accept_backlog"`           // unacknowledged streams, each way
+		MaxIncomingStreams     uint32       `yaml:"max_incoming_streams"`
+		MaxMessageSize         uint32       `yaml:"max_message_size"` // bytes per data frame
+		StreamOpenTimeout      yamlDuration `yaml:"stream_open_timeout"`
+		StreamCloseTimeout     yamlDuration `yaml:"stream_close_timeout"`
+		MeasureRTTInterval     yamlDuration `yaml:"measure_rtt_interval"`
+		Stats                  bool         `yaml:"stats"`          // report per-session statistics
+		StatsInterval          yamlDuration `yaml:"stats_interval"` // ...also periodically
+		Trace                  string       `yaml:"trace"`          // frame trace file, or "-" for stdout events
 	} `yaml:"yamux"`
+	Listen struct {
+		IPv6 bool `yaml:"ipv6"` // also listen on /ip6/::
+	} `yaml:"listen"`
+	ResourceManager *ResourceManagerConfig `yaml:"resource_manager"`
+	ConnManager     *ConnManagerConfig     `yaml:"conn_manager"`
+	Gater           *GaterConfig           `yaml:"gater"`
+	Security        string                 `yaml:"security"`    // noise (default), tls, or both in order of preference
+	EarlyMuxer      *bool                  `yaml:"early_muxer"` // negotiate the muxer in the security handshake (default true)
+	Muxers          []string               `yaml:"muxers"`      // /yamux/ IDs in order of preference
+	PrivateNetwork  struct {
+		PSKFile  string `yaml:"psk_file"` // swarm key in /key/swarm/psk/1.0.0/ format
+		Mismatch bool   `yaml:"mismatch"` // invert the key to provoke handshake failures
+	} `yaml:"private_network"`
+	Ping struct {
+		Delay  yamlDuration `yaml:"delay"`  // hold every pong this long...
+		Jitter yamlDuration `yaml:"jitter"` // ...plus a random extra up to this
+	} `yaml:"ping"`
+	Identity struct {
+		KeyFile string `yaml:"key_file"` // protobuf-marshalled private key, created if missing
+		Seed    string `yaml:"seed"`     // derive the key deterministically from this string
+		KeyType string `yaml:"key_type"` // ed25519 (default), rsa, secp256k1 or ecdsa
+	} `yaml:"identity"`
 }
 
 func loadConfig(path string) (*PeerConfig, error) {
@@ -77,6 +105,24 @@ func yamuxTransport(cfg *PeerConfig) *yamux.Transport {
 	if cfg.Yamux.ConnectionWriteTimeout > 0 {
 		goConfig.ConnectionWriteTimeout = time.Duration(cfg.Yamux.ConnectionWriteTimeout) * time.Second
 	}
+	if cfg.Yamux.InitialStreamWindow > 0 {
+		goConfig.InitialStreamWindowSize = cfg.Yamux.InitialStreamWindow
+	}
+	if cfg.Yamux.MaxStreamWindow > 0 {
+		goConfig.MaxStreamWindowSize = cfg.Yamux.MaxStreamWindow
+	}
+	if cfg.Yamux.AcceptBacklog > 0 {
+		goConfig.AcceptBacklog = cfg.Yamux.AcceptBacklog
+	}
+	if cfg.Yamux.MaxIncomingStreams > 0 {
+		goConfig.MaxIncomingStreams = cfg.Yamux.MaxIncomingStreams
+	}
+	if cfg.Yamux.MaxMessageSize > 0 {
+		goConfig.MaxMessageSize = cfg.Yamux.MaxMessageSize
+	}
+	if cfg.Yamux.MeasureRTTInterval > 0 {
+		goConfig.MeasureRTTInterval = time.Duration(cfg.Yamux.MeasureRTTInterval)
+	}
 	return (*yamux.Transport)(goConfig)
 }
 
@@ -90,7 +136,7 @@ func main() {
 		}
 	}()
 
-	mode := flag.String("mode", "server", "Mode: server, client, ping, echo-server, echo-client, push-test, relay, relay-echo-server, relay-echo-client, dht-server, dht-relay-server, dht-put-value, dht-get-value, dht-provide, dht-find-providers, pubsub-server, pubsub-client")
+	mode := flag.String("mode", "server", "Mode: server, client, ping, echo-server, echo-client, push-test, relay, relay-echo-server, relay-echo-client, dht-server, dht-relay-server, dht-put-value, dht-get-value, dht-provide, dht-find-providers, pubsub-server, pubsub-client, daemon, p2pd, close-behavior, slow-echo-server, stream-stress, perf-server, perf-client")
 	port := flag.Int("port", 0, "Listen port (0 for random)")
 	target := flag.String("target", "", "Target multiaddr for client/ping modes")
 	message := flag.String("message", "hello from go-libp2p", "Message to send in echo-client mode")
@@ -101,19 +147,159 @@ func main() {
 	pkSelf := flag.Bool("pk-self", false, "For dht-put-value: store own public key as /pk/<self> record")
 	pkPeer := flag.String("pk-peer", "", "PeerId (base58) to construct /pk/<raw-id> key for get-value")
 	topic := flag.String("topic", "test-topic", "PubSub topic name")
-	transport := flag.String("transport", "tcp", "Transport: tcp or udx")
+	transport := flag.String("transport", "tcp", "Transports to listen on, comma-separated: tcp, udx, quic, ws, wss")
 	configPath := flag.String("config", "", "Path to YAML config file")
+	socketPath := flag.String("socket", "/tmp/p2pd.sock", "Unix socket path for the p2pd control protocol")
+	output := flag.String("output", "text", "Stdout format: text or json (newline-delimited events)")
+	scenarioPath := flag.String("scenario", "", "Path to a YAML scenario to run instead of --mode")
+	ipv6 := flag.Bool("ipv6", false, "Also listen on /ip6/:: for every transport")
+	keyFile := flag.String("key-file", "", "Private key file (libp2p protobuf format); created if missing")
+	seed := flag.String("seed", "", "Derive the host key deterministically from this seed")
+	keyType := flag.String("key-type", "", "Host key type: ed25519 (default), rsa, secp256k1 or ecdsa")
+	servicesFlag := flag.String("services", "", "Comma-separated services to mount instead of --mode: echo, ping, relay, dht, pubsub, autonat, holepunch, perf")
+	trimAfter := flag.Duration("trim-after", 0, "Force a connection manager trim this long after startup")
+	closePeer := flag.String("close-peer", "", "Close all connections to this peer whenever it connects")
+	closeAfter := flag.Duration("close-after", time.Second, "Delay before --close-peer closes the connections")
+	security := flag.String("security", "", "Security transports in order of preference: noise (default), tls, noise,tls or tls,noise")
+	earlyMuxer := flag.String("early-muxer", "", "Negotiate the muxer inside the Noise/TLS handshake: true (go-libp2p default) or false")
+	muxers := flag.String("muxers", "", "Comma-separated /yamux/ muxer IDs to offer, in order of preference (default /yamux/1.0.0)")
+	yamuxStats := flag.Bool("yamux-stats", false, "Report yamux session statistics on the yamux-stats stdin command and when sessions end")
+	yamuxStatsInterval := flag.Duration("yamux-stats-interval", 0, "Also report yamux session statistics this often (implies --yamux-stats)")
+	traceYamux := flag.String("trace-yamux", "", "Log every yamux frame to this file (JSON lines for .json/.jsonl), or - for stdout events")
+	closeAction := flag.String("close-behavior", "reset", "For close-behavior: reset, close-write, goaway-normal, goaway-protocol, goaway-internal or drop")
+	afterBytes := flag.Int("after-bytes", 0, "For close-behavior: bytes to echo on each stream before acting")
+	observe := flag.Duration("observe", 5*time.Second, "For close-behavior: how long to report the remote's reaction")
+	readRate := flag.Int("read-rate", 0, "For slow-echo-server: read each stream at most this many bytes per second (0 for unthrottled)")
+	pause := flag.Duration("pause", 0, "For slow-echo-server: stop reading each stream for this long")
+	pauseAfter := flag.Int("pause-after", 0, "For slow-echo-server: bytes to read on each stream before --pause")
+	streams := flag.Int("streams", 100, "For stream-stress: number of /echo/1.0.0 streams to run")
+	concurrency := flag.Int("concurrency", 0, "For stream-stress: streams open at once (default all of --streams)")
+	payloadSize := flag.Int("payload-size", 64*1024, "For stream-stress: size of each random payload in bytes")
+	payloads := flag.Int("payloads", 1, "For stream-stress: payloads to echo on each stream")
+	count := flag.Int("count", 1, "For ping: number of pings to send")
+	interval := flag.Duration("interval", time.Second, "For ping: time between pings")
+	reuseStream := flag.Bool("reuse-stream", false, "For ping: send every ping on one stream instead of a fresh one each")
+	pingTimeout := flag.Duration("ping-timeout", 10*time.Second, "For ping: how long to wait for each pong before counting it lost")
+	pingDelay := flag.Duration("ping-delay", 0, "Hold every pong the ping service sends for this long")
+	pingJitter := flag.Duration("ping-jitter", 0, "Add a random delay up to this to every pong the ping service sends")
+	uploadBytes := flag.Uint64("upload-bytes", 100<<20, "For perf-client: bytes to upload")
+	downloadBytes := flag.Uint64("download-bytes", 100<<20, "For perf-client: bytes to request from the server")
+	pskFile := flag.String("psk-file", "", "Private network swarm key file (/key/swarm/psk/1.0.0/ format)")
+	pskMismatch := flag.Bool("psk-mismatch", false, "Invert the --psk-file key so handshakes with correctly keyed peers fail")
 	flag.Parse()
 
-	var cfg *PeerConfig
+	switch *output {
+	case "text":
+	case "json":
+		outputJSON = true
+	default:
+		fatal("usage", "Unknown output format: %s", *output)
+	}
+
+	cfg := &PeerConfig{}
 	if *configPath != "" {
 		var err error
 		cfg, err = loadConfig(*configPath)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
-			os.Exit(1)
+			fatal("config", "Error loading config: %v", err)
 		}
 	}
+	if _, err := parseTransports(*transport); err != nil {
+		fatal("usage", "Error: %v", err)
+	}
+
+	// Flags take precedence over the config file.
+	if *ipv6 {
+		cfg.Listen.IPv6 = true
+	}
+	if *keyFile != "" {
+		cfg.Identity.KeyFile = *keyFile
+	}
+	if *seed != "" {
+		cfg.Identity.Seed = *seed
+	}
+	if *keyType != "" {
+		if _, ok := keyTypes[*keyType]; !ok {
+			fatal("usage", "Unknown key type: %s", *keyType)
+		}
+		cfg.Identity.KeyType = *keyType
+	}
+
+	if *security != "" {
+		if _, err := parseSecurity(*security); err != nil {
+			fatal("usage", "Error: %v", err)
+		}
+		cfg.Security = *security
+	}
+	if *earlyMuxer != "" {
+		early, err := strconv.ParseBool(*earlyMuxer)
+		if err != nil {
+			fatal("usage", "Invalid --early-muxer: %s", *earlyMuxer)
+		}
+		cfg.EarlyMuxer = &early
+	}
+	if *muxers != "" {
+		cfg.Muxers = nil
+		for _, id := range strings.Split(*muxers, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				cfg.Muxers = append(cfg.Muxers, id)
+			}
+		}
+	}
+	if *yamuxStats || *yamuxStatsInterval > 0 {
+		cfg.Yamux.Stats = true
+	}
+	if *yamuxStatsInterval > 0 {
+		cfg.Yamux.StatsInterval = yamlDuration(*yamuxStatsInterval)
+	}
+	if *traceYamux != "" {
+		cfg.Yamux.Trace = *traceYamux
+	}
+	if *pskFile != "" {
+		cfg.PrivateNetwork.PSKFile = *pskFile
+	}
+	if *pskMismatch {
+		cfg.PrivateNetwork.Mismatch = true
+	}
+	if *pingDelay > 0 {
+		cfg.Ping.Delay = yamlDuration(*pingDelay)
+	}
+	if *pingJitter > 0 {
+		cfg.Ping.Jitter = yamlDuration(*pingJitter)
+	}
+
+	if *trimAfter > 0 || *closePeer != "" {
+		if cfg.ConnManager == nil {
+			cfg.ConnManager = &ConnManagerConfig{}
+		}
+		if *trimAfter > 0 {
+			cfg.ConnManager.TrimAfter = yamlDuration(*trimAfter)
+		}
+		if *closePeer != "" {
+			if _, err := peer.Decode(*closePeer); err != nil {
+				fatal("usage", "Invalid --close-peer: %v", err)
+			}
+			cfg.ConnManager.ClosePeer = *closePeer
+			cfg.ConnManager.CloseAfter = yamlDuration(*closeAfter)
+		}
+	}
+
+	defer flushYamuxTraces()
+
+	if *scenarioPath != "" {
+		runScenario(*scenarioPath, *port, *transport, *target, cfg)
+		return
+	}
+	startYamuxStats(cfg)
+
+	if *servicesFlag != "" {
+		services, err := parseServices(*servicesFlag)
+		if err != nil {
+			fatal("usage", "Error: %v", err)
+		}
+		runServices(*port, *transport, services, *topic, cfg)
+		return
+	}
 
 	switch *mode {
 	case "server":
@@ -121,7 +307,7 @@ func main() {
 	case "client":
 		runClient(*target, *transport, cfg)
 	case "ping":
-		runPing(*target, *transport, cfg)
+		runPing(*target, *transport, *count, *interval, *reuseStream, *pingTimeout, cfg)
 	case "echo-server":
 		runEchoServer(*port, *transport, cfg)
 	case "echo-client":
@@ -129,86 +315,139 @@ func main() {
 	case "push-test":
 		runPushTest(*target, *transport, cfg)
 	case "relay":
-		runRelay(*port, cfg)
+		runRelay(*port, *transport, cfg)
 	case "relay-echo-server":
-		runRelayEchoServer(*relayAddr, cfg)
+		runRelayEchoServer(*relayAddr, *transport, cfg)
 	case "relay-echo-client":
-		runRelayEchoClient(*target, *message, cfg)
+		runRelayEchoClient(*target, *message, *transport, cfg)
 	case "dht-server":
-		runDHTServer(*port, cfg)
+		runDHTServer(*port, *transport, cfg)
 	case "dht-relay-server":
 		runDHTRelayServer(*port, *transport, cfg)
 	case "dht-put-value":
-		runDHTPutValue(*target, *key, *value, *pkSelf, cfg)
+		runDHTPutValue(*target, *key, *value, *pkSelf, *transport, cfg)
 	case "dht-get-value":
-		runDHTGetValue(*target, *key, *pkPeer, cfg)
+		runDHTGetValue(*target, *key, *pkPeer, *transport, cfg)
 	case "dht-provide":
-		runDHTProvide(*target, *cidStr, cfg)
+		runDHTProvide(*target, *cidStr, *transport, cfg)
 	case "dht-find-providers":
-		runDHTFindProviders(*target, *cidStr, cfg)
+		runDHTFindProviders(*target, *cidStr, *transport, cfg)
 	case "pubsub-server":
-		runPubSubServer(*port, *topic, cfg)
+		runPubSubServer(*port, *transport, *topic, cfg)
 	case "pubsub-client":
-		runPubSubClient(*target, *topic, *message, cfg)
+		runPubSubClient(*target, *topic, *message, *transport, cfg)
+	case "daemon":
+		runDaemon(*port, *transport, cfg)
+	case "p2pd":
+		runP2PD(*port, *transport, *socketPath, cfg)
+	case "close-behavior":
+		runCloseBehaviorServer(*port, *transport, *closeAction, *afterBytes, *observe, cfg)
+	case "slow-echo-server":
+		runSlowEchoServer(*port, *transport, *readRate, *pause, *pauseAfter, cfg)
+	case "stream-stress":
+		runStreamStress(*target, *transport, *streams, *concurrency, *payloadSize, *payloads, cfg)
+	case "perf-server":
+		runPerfServer(*port, *transport, cfg)
+	case "perf-client":
+		runPerfClient(*target, *transport, *uploadBytes, *downloadBytes, cfg)
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown mode: %s\n", *mode)
-		os.Exit(1)
+		fatal("usage", "Unknown mode: %s", *mode)
 	}
 }
 
-func transportOpts(transport string, port int) []libp2p.Option {
-	if transport == "udx" {
-		return []libp2p.Option{
-			libp2p.NoTransports,
-			libp2p.Transport(udxtransport.NewTransport),
-			libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/udx", port)),
-			libp2p.ResourceManager(&network.NullResourceManager{}),
-		}
-	}
-	return []libp2p.Option{
-		libp2p.Transport(tcp.NewTCPTransport),
-		libp2p.ListenAddrStrings(fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port)),
+// createHost builds a host without the relay client. Options in extra are
+// appended last, so they override the defaults.
+func createHost(port int, transport string, cfg *PeerConfig, extra ...libp2p.Option) (host.Host, error) {
+	opts, err := hostOptions(port, transport, cfg)
+	if err != nil {
+		return nil, err
 	}
+	opts = append(opts, libp2p.DisableRelay())
+	opts = append(opts, extra...)
+	return newHost(cfg, opts...)
 }
 
-func createHost(port int, transport string, cfg *PeerConfig) (host.Host, error) {
-	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+func createHostWithRelay(port int, transport string, cfg *PeerConfig, extra ...libp2p.Option) (host.Host, error) {
+	opts, err := hostOptions(port, transport, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("generate key: %w", err)
+		return nil, err
 	}
+	opts = append(opts, libp2p.EnableRelay())
+	opts = append(opts, extra...)
+	return newHost(cfg, opts...)
+}
 
-	opts := []libp2p.Option{
-		libp2p.Identity(priv),
-		libp2p.Security(noise.ID, noise.New),
-		libp2p.Muxer("/yamux/1.0.0", yamuxTransport(cfg)),
-		libp2p.DisableRelay(),
+// newHost builds the host and starts what has to run alongside it.
+func newHost(cfg *PeerConfig, opts ...libp2p.Option) (host.Host, error) {
+	h, err := libp2p.New(opts...)
+	if err != nil {
+		return nil, err
 	}
-	opts = append(opts, transportOpts(transport, port)...)
-	return libp2p.New(opts...)
+	startConnManager(h, cfg)
+	startConnReport(h, cfg)
+	startPingDelay(h, cfg)
+	return h, nil
 }
 
-func createHostWithRelay(port int, transport string, cfg *PeerConfig) (host.Host, error) {
-	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+// hostOptions returns the options every host shares: identity, security,
+// muxer, transports, private network, resource manager, connection manager
+// and gater.
+func hostOptions(port int, transport string, cfg *PeerConfig) ([]libp2p.Option, error) {
+	priv, err := hostKey(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("generate key: %w", err)
+		return nil, err
 	}
 
-	opts := []libp2p.Option{
-		libp2p.Identity(priv),
-		libp2p.Security(noise.ID, noise.New),
-		libp2p.Muxer("/yamux/1.0.0", yamuxTransport(cfg)),
-		libp2p.EnableRelay(),
+	secOpts, err := securityOpts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := []libp2p.Option{libp2p.Identity(priv)}
+	opts = append(opts, secOpts...)
+	mOpts, err := muxerOpts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, mOpts...)
+	tOpts, err := transportOpts(transport, port, cfg != nil && cfg.Listen.IPv6)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, tOpts...)
+	pnetOpts, err := privateNetworkOpts(cfg, transport)
+	if err != nil {
+		return nil, err
 	}
-	opts = append(opts, transportOpts(transport, port)...)
-	return libp2p.New(opts...)
+	opts = append(opts, pnetOpts...)
+	rmOpts, err := resourceManagerOpts(cfg, transport)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, rmOpts...)
+	cmOpts, err := connManagerOpts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, cmOpts...)
+	gOpts, err := connectionGaterOpts(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return append(opts, gOpts...), nil
 }
 
 func printHostInfo(h host.Host) {
-	fmt.Printf("PeerID: %s\n", h.ID())
+	emit(Event{Type: "peer_id", Peer: h.ID().String(), KeyType: keyTypeName(h)}, "PeerID: %s", h.ID())
 	for _, addr := range h.Addrs() {
-		fmt.Printf("Listening: %s/p2p/%s\n", addr, h.ID())
+		full := fmt.Sprintf("%s/p2p/%s", addr, h.ID())
+		emit(Event{Type: "listening", Peer: h.ID().String(), Addrs: []string{full}}, "Listening: %s", full)
 	}
-	fmt.Println("Ready")
+	emit(Event{Type: "ready", Peer: h.ID().String()}, "Ready")
+}
+
+// emitCircuitAddr reports a relayed address other peers can dial us on.
+func emitCircuitAddr(addr string) {
+	emit(Event{Type: "circuit_addr", Addrs: []string{addr}}, "CircuitAddr: %s", addr)
 }
 
 func parseTarget(targetStr string) (*peer.AddrInfo, error) {
@@ -223,22 +462,13 @@ func waitForShutdown() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 	<-ch
-	fmt.Println("Shutting down")
+	emit(Event{Type: "shutdown"}, "Shutting down")
 }
 
-// server mode: listen and accept connections, handle ping and identify automatically
-func runServer(port int, transport string, cfg *PeerConfig) {
-	h, err := createHost(port, transport, cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer h.Close()
-
-	printHostInfo(h)
-
-	// Also handle echo protocol
-	h.SetStreamHandler(protocol.ID(echoProtocol), func(s network.Stream) {
+// echoHandler returns a stream handler that writes every chunk it reads back
+// to the sender. With logReads set, each chunk size is printed to stdout.
+func echoHandler(logReads bool) network.StreamHandler {
+	return func(s network.Stream) {
 		defer s.Close()
 		buf := make([]byte, 64*1024)
 		for {
@@ -249,23 +479,32 @@ func runServer(port int, transport string, cfg *PeerConfig) {
 				}
 				return
 			}
+			if logReads {
+				emit(Event{Type: "echo_received", Peer: s.Conn().RemotePeer().String(), Bytes: n}, "Echo: received %d bytes", n)
+			}
 			if _, err := s.Write(buf[:n]); err != nil {
 				fmt.Fprintf(os.Stderr, "Echo write error: %v\n", err)
 				return
 			}
 		}
-	})
+	}
+}
+
+// server mode: listen and accept connections, handle ping and identify automatically
+func runServer(port int, transport string, cfg *PeerConfig) {
+	h, err := createHost(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	printHostInfo(h)
+
+	// Also handle echo protocol
+	h.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(false))
 
 	// Listen for commands on stdin
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
+	watchStdinQuit(nil)
 
 	waitForShutdown()
 }
@@ -273,131 +512,69 @@ func runServer(port int, transport string, cfg *PeerConfig) {
 // client mode: connect to target peer
 func runClient(targetStr, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
 	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
-	fmt.Printf("Connected: %s\n", info.ID)
+	emit(Event{Type: "connected", Peer: info.ID.String()}, "Connected: %s", info.ID)
 }
 
 // ping mode: connect and send pings
-func runPing(targetStr, transport string, cfg *PeerConfig) {
+func runPing(targetStr, transport string, count int, interval time.Duration, reuse bool, timeout time.Duration, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
 	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Use the built-in ping protocol
-	s, err := h.NewStream(ctx, info.ID, "/ipfs/ping/1.0.0")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Ping stream failed: %v\n", err)
-		os.Exit(1)
-	}
-	defer s.Close()
-
-	pingData := make([]byte, 32)
-	rand.Read(pingData)
-
-	start := time.Now()
-	if _, err := s.Write(pingData); err != nil {
-		fmt.Fprintf(os.Stderr, "Ping write failed: %v\n", err)
-		os.Exit(1)
-	}
-
-	resp := make([]byte, 32)
-	if _, err := io.ReadFull(s, resp); err != nil {
-		fmt.Fprintf(os.Stderr, "Ping read failed: %v\n", err)
-		os.Exit(1)
-	}
-	rtt := time.Since(start)
-
-	match := true
-	for i := range pingData {
-		if pingData[i] != resp[i] {
-			match = false
-			break
-		}
+		fatal("dial", "Connection failed: %v", err)
 	}
 
-	if match {
-		fmt.Printf("Ping successful: rtt=%v\n", rtt)
-	} else {
-		fmt.Fprintln(os.Stderr, "Ping failed: data mismatch")
-		os.Exit(1)
-	}
+	pingRounds(h, info.ID, max(count, 1), interval, reuse, timeout)
 }
 
 // echo-server mode: listen and echo data back
 func runEchoServer(port int, transport string, cfg *PeerConfig) {
 	h, err := createHost(port, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
-	h.SetStreamHandler(protocol.ID(echoProtocol), func(s network.Stream) {
-		defer s.Close()
-		buf := make([]byte, 64*1024)
-		for {
-			n, err := s.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "Echo read error: %v\n", err)
-				}
-				return
-			}
-			fmt.Printf("Echo: received %d bytes\n", n)
-			if _, err := s.Write(buf[:n]); err != nil {
-				fmt.Fprintf(os.Stderr, "Echo write error: %v\n", err)
-				return
-			}
-		}
-	})
+	h.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(true))
 
 	printHostInfo(h)
+	watchStdinQuit(nil)
 	waitForShutdown()
 }
 
@@ -405,32 +582,28 @@ func runEchoServer(port int, transport string, cfg *PeerConfig) {
 // protocol handler to trigger an identify push notification to the remote peer.
 func runPushTest(targetStr, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
 	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
-	fmt.Printf("PeerID: %s\n", h.ID())
-	fmt.Println("Connected")
+	emit(Event{Type: "peer_id", Peer: h.ID().String()}, "PeerID: %s", h.ID())
+	emit(Event{Type: "connected", Peer: info.ID.String()}, "Connected")
 
 	// Wait for identify to complete
 	time.Sleep(2 * time.Second)
@@ -440,122 +613,73 @@ func runPushTest(targetStr, transport string, cfg *PeerConfig) {
 	h.SetStreamHandler(protocol.ID(pushTestProto), func(s network.Stream) {
 		s.Close()
 	})
-	fmt.Printf("Registered protocol: %s\n", pushTestProto)
+	emit(Event{Type: "protocol_registered", Protocol: pushTestProto}, "Registered protocol: %s", pushTestProto)
 
 	// Give the push time to propagate
 	time.Sleep(3 * time.Second)
-	fmt.Println("Push test complete")
+	emit(Event{Type: "done"}, "Push test complete")
 }
 
 // relay mode: run a circuit relay v2 service
-func runRelay(port int, cfg *PeerConfig) {
-	h, err := createHostWithRelay(port, "tcp", cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer h.Close()
-
-	_, err = relayv2.New(h)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Relay service error: %v\n", err)
-		os.Exit(1)
-	}
-
-	printHostInfo(h)
-
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
-
-	waitForShutdown()
+func runRelay(port int, transport string, cfg *PeerConfig) {
+	runServices(port, transport, []string{"ping", "relay"}, "", cfg)
 }
 
 // relay-echo-server mode: connect to relay, reserve, then handle echo streams
-func runRelayEchoServer(relayAddrStr string, cfg *PeerConfig) {
+func runRelayEchoServer(relayAddrStr, transport string, cfg *PeerConfig) {
 	if relayAddrStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --relay required")
-		os.Exit(1)
+		fatal("usage", "Error: --relay required")
 	}
 
-	h, err := createHostWithRelay(0, "tcp", cfg)
+	h, err := createHostWithRelay(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	// Parse relay address and connect
 	relayInfo, err := parseTarget(relayAddrStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing relay addr: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error parsing relay addr: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *relayInfo); err != nil {
-		fmt.Fprintf(os.Stderr, "Relay connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Relay connection failed: %v", err)
 	}
-	fmt.Println("Connected to relay")
+	emit(Event{Type: "connected", Peer: relayInfo.ID.String()}, "Connected to relay")
 
 	// Reserve a slot on the relay using the client package
 	rsvp, err := relayv2client.Reserve(ctx, h, *relayInfo)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Relay reservation failed: %v\n", err)
-		os.Exit(1)
+		fatal("relay", "Relay reservation failed: %v", err)
 	}
-	fmt.Printf("Reservation expires: %v\n", rsvp.Expiration)
+	emit(Event{Type: "reservation", Peer: relayInfo.ID.String(), Expiration: rsvp.Expiration}, "Reservation expires: %v", rsvp.Expiration)
 
 	// Listen on the relay circuit address so we can accept incoming relayed connections
 	relayMA, err := multiaddr.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit", relayInfo.ID))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating relay listen addr: %v\n", err)
-		os.Exit(1)
+		fatal("relay", "Error creating relay listen addr: %v", err)
 	}
 	if err := h.Network().Listen(relayMA); err != nil {
-		fmt.Fprintf(os.Stderr, "Relay listen failed: %v\n", err)
-		os.Exit(1)
+		fatal("relay", "Relay listen failed: %v", err)
 	}
 
 	// Set echo handler
-	h.SetStreamHandler(protocol.ID(echoProtocol), func(s network.Stream) {
-		defer s.Close()
-		buf := make([]byte, 64*1024)
-		for {
-			n, err := s.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "Echo read error: %v\n", err)
-				}
-				return
-			}
-			fmt.Printf("Echo: received %d bytes\n", n)
-			if _, err := s.Write(buf[:n]); err != nil {
-				fmt.Fprintf(os.Stderr, "Echo write error: %v\n", err)
-				return
-			}
-		}
-	})
+	h.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(true))
 
 	// Wait for the relay reservation to propagate
 	time.Sleep(2 * time.Second)
 
 	// Print circuit address for clients to connect to
-	fmt.Printf("PeerID: %s\n", h.ID())
+	emit(Event{Type: "peer_id", Peer: h.ID().String()}, "PeerID: %s", h.ID())
 	foundCircuit := false
 	for _, addr := range h.Addrs() {
 		addrStr := addr.String()
 		if strings.Contains(addrStr, "p2p-circuit") {
-			fmt.Printf("CircuitAddr: %s/p2p/%s\n", addr, h.ID())
+			emitCircuitAddr(fmt.Sprintf("%s/p2p/%s", addr, h.ID()))
 			foundCircuit = true
 		}
 	}
@@ -564,55 +688,43 @@ func runRelayEchoServer(relayAddrStr string, cfg *PeerConfig) {
 		for _, raddr := range relayInfo.Addrs {
 			raddrStr := raddr.String()
 			if strings.Contains(raddrStr, "127.0.0.1") {
-				fmt.Printf("CircuitAddr: %s/p2p/%s/p2p-circuit/p2p/%s\n", raddr, relayInfo.ID, h.ID())
+				emitCircuitAddr(fmt.Sprintf("%s/p2p/%s/p2p-circuit/p2p/%s", raddr, relayInfo.ID, h.ID()))
 				foundCircuit = true
 				break
 			}
 		}
 		if !foundCircuit {
-			fmt.Printf("CircuitAddr: %s/p2p/%s/p2p-circuit/p2p/%s\n", relayInfo.Addrs[0], relayInfo.ID, h.ID())
+			emitCircuitAddr(fmt.Sprintf("%s/p2p/%s/p2p-circuit/p2p/%s", relayInfo.Addrs[0], relayInfo.ID, h.ID()))
 		}
 	}
-	fmt.Println("Ready")
+	emit(Event{Type: "ready", Peer: h.ID().String()}, "Ready")
 
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
+	watchStdinQuit(nil)
 
 	waitForShutdown()
 }
 
 // relay-echo-client mode: connect to peer through relay and send echo
-func runRelayEchoClient(targetStr, message string, cfg *PeerConfig) {
+func runRelayEchoClient(targetStr, message, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
-	h, err := createHostWithRelay(0, "tcp", cfg)
+	h, err := createHostWithRelay(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	// Parse the circuit address — contains relay + destination
 	targetMA, err := multiaddr.NewMultiaddr(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing target: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error parsing target: %v", err)
 	}
 
 	info, err := peer.AddrInfoFromP2pAddr(targetMA)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error extracting peer info: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error extracting peer info: %v", err)
 	}
 
 	// Add the circuit address to the peerstore
@@ -623,223 +735,105 @@ func runRelayEchoClient(targetStr, message string, cfg *PeerConfig) {
 
 	// Connect through the relay
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Circuit connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Circuit connection failed: %v", err)
 	}
-	fmt.Println("Connected through relay")
+	emit(Event{Type: "connected", Peer: info.ID.String()}, "Connected through relay")
 
 	// Use WithAllowLimitedConn to allow streams over transient (relayed) connections
 	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "relay-echo-client"), info.ID, protocol.ID(echoProtocol))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Stream failed: %v\n", err)
-		os.Exit(1)
+		fatal("stream", "Stream failed: %v", err)
 	}
 	defer s.Close()
 
 	data := []byte(message)
 	if _, err := s.Write(data); err != nil {
-		fmt.Fprintf(os.Stderr, "Write failed: %v\n", err)
-		os.Exit(1)
+		fatal("io", "Write failed: %v", err)
 	}
 	s.CloseWrite()
 
 	resp, err := io.ReadAll(s)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Read failed: %v\n", err)
-		os.Exit(1)
+		fatal("io", "Read failed: %v", err)
 	}
 
 	if string(resp) == message {
-		fmt.Printf("Echo successful: %q\n", string(resp))
+		emit(Event{Type: "echo", Peer: info.ID.String(), Protocol: echoProtocol, Bytes: len(resp)}, "Echo successful: %q", string(resp))
 	} else {
-		fmt.Fprintf(os.Stderr, "Echo mismatch: sent %q, got %q\n", message, string(resp))
-		os.Exit(1)
+		fatal("mismatch", "Echo mismatch: sent %q, got %q", message, string(resp))
 	}
 }
 
 // echo-client mode: connect and send a message
 func runEchoClient(targetStr, message, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
 	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
 	s, err := h.NewStream(ctx, info.ID, protocol.ID(echoProtocol))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Stream failed: %v\n", err)
-		os.Exit(1)
+		fatal("stream", "Stream failed: %v", err)
 	}
 	defer s.Close()
 
 	data := []byte(message)
 	if _, err := s.Write(data); err != nil {
-		fmt.Fprintf(os.Stderr, "Write failed: %v\n", err)
-		os.Exit(1)
+		fatal("io", "Write failed: %v", err)
 	}
 	s.CloseWrite()
 
 	resp, err := io.ReadAll(s)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Read failed: %v\n", err)
-		os.Exit(1)
+		fatal("io", "Read failed: %v", err)
 	}
 
 	if string(resp) == message {
-		fmt.Printf("Echo successful: %q\n", string(resp))
+		emit(Event{Type: "echo", Peer: info.ID.String(), Protocol: echoProtocol, Bytes: len(resp)}, "Echo successful: %q", string(resp))
 	} else {
-		fmt.Fprintf(os.Stderr, "Echo mismatch: sent %q, got %q\n", message, string(resp))
-		os.Exit(1)
+		fatal("mismatch", "Echo mismatch: sent %q, got %q", message, string(resp))
 	}
 }
 
 // dht-server mode: run a Kademlia DHT server
-func runDHTServer(port int, cfg *PeerConfig) {
-	h, err := createHost(port, "tcp", cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer h.Close()
-
-	ctx := context.Background()
-	// Use permissive options for local testing: allow private/loopback addresses
-	kadDHT, err := dht.New(ctx, h,
-		dht.Mode(dht.ModeServer),
-		dht.AddressFilter(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
-			return addrs // Accept all addresses including loopback
-		}),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
-	}
-	defer kadDHT.Close()
-
-	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
-	}
-
-	printHostInfo(h)
-
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
-
-	waitForShutdown()
+func runDHTServer(port int, transport string, cfg *PeerConfig) {
+	runServices(port, transport, []string{"ping", "dht"}, "", cfg)
 }
 
 // dht-relay-server mode: run a combined DHT server + circuit relay v2 service
 func runDHTRelayServer(port int, transport string, cfg *PeerConfig) {
-	h, err := createHostWithRelay(port, transport, cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer h.Close()
-
-	// Start relay service
-	_, err = relayv2.New(h)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Relay service error: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Start DHT in server mode
-	ctx := context.Background()
-	kadDHT, err := dht.New(ctx, h,
-		dht.Mode(dht.ModeServer),
-		dht.AddressFilter(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
-			return addrs // Accept all addresses including loopback
-		}),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
-	}
-	defer kadDHT.Close()
-
-	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Echo handler for basic connectivity checks
-	h.SetStreamHandler(protocol.ID(echoProtocol), func(s network.Stream) {
-		defer s.Close()
-		buf := make([]byte, 64*1024)
-		for {
-			n, err := s.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "Echo read error: %v\n", err)
-				}
-				return
-			}
-			if _, err := s.Write(buf[:n]); err != nil {
-				fmt.Fprintf(os.Stderr, "Echo write error: %v\n", err)
-				return
-			}
-		}
-	})
-
-	printHostInfo(h)
-
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
-
-	waitForShutdown()
+	runServices(port, transport, []string{"ping", "relay", "dht", "echo"}, "", cfg)
 }
 
 // dht-put-value mode: connect to target DHT peer and store a value
-func runDHTPutValue(targetStr, key, value string, pkSelf bool, cfg *PeerConfig) {
+func runDHTPutValue(targetStr, key, value string, pkSelf bool, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 	if !pkSelf && (key == "" || value == "") {
-		fmt.Fprintln(os.Stderr, "Error: --key and --value required (or use --pk-self)")
-		os.Exit(1)
+		fatal("usage", "Error: --key and --value required (or use --pk-self)")
 	}
 
-	h, err := createHost(0, "tcp", cfg)
+	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
@@ -848,25 +842,21 @@ func runDHTPutValue(targetStr, key, value string, pkSelf bool, cfg *PeerConfig)
 
 	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeClient))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT error: %v", err)
 	}
 	defer kadDHT.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
 	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT bootstrap error: %v", err)
 	}
 	// Allow routing table to populate
 	time.Sleep(2 * time.Second)
@@ -877,39 +867,33 @@ func runDHTPutValue(targetStr, key, value string, pkSelf bool, cfg *PeerConfig)
 		pkKey := "/pk/" + string(h.ID())
 		pubKeyBytes, err := crypto.MarshalPublicKey(h.Peerstore().PubKey(h.ID()))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to marshal public key: %v\n", err)
-			os.Exit(1)
+			fatal("crypto", "Failed to marshal public key: %v", err)
 		}
 		if err := kadDHT.PutValue(ctx, pkKey, pubKeyBytes); err != nil {
-			fmt.Fprintf(os.Stderr, "PutValue /pk/ failed: %v\n", err)
-			os.Exit(1)
+			fatal("dht", "PutValue /pk/ failed: %v", err)
 		}
-		fmt.Printf("PeerID: %s\n", h.ID())
-		fmt.Println("Put /pk/ successful")
+		emit(Event{Type: "peer_id", Peer: h.ID().String(), KeyType: keyTypeName(h)}, "PeerID: %s", h.ID())
+		emit(Event{Type: "dht_put", Key: "/pk/" + h.ID().String(), Bytes: len(pubKeyBytes), KeyType: keyTypeName(h)}, "Put /pk/ successful")
 	} else {
 		if err := kadDHT.PutValue(ctx, key, []byte(value)); err != nil {
-			fmt.Fprintf(os.Stderr, "PutValue failed: %v\n", err)
-			os.Exit(1)
+			fatal("dht", "PutValue failed: %v", err)
 		}
-		fmt.Println("Put successful")
+		emit(Event{Type: "dht_put", Key: key, Bytes: len(value)}, "Put successful")
 	}
 }
 
 // dht-get-value mode: connect to target DHT peer and retrieve a value
-func runDHTGetValue(targetStr, key, pkPeerStr string, cfg *PeerConfig) {
+func runDHTGetValue(targetStr, key, pkPeerStr, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 	if key == "" && pkPeerStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --key or --pk-peer required")
-		os.Exit(1)
+		fatal("usage", "Error: --key or --pk-peer required")
 	}
 
-	h, err := createHost(0, "tcp", cfg)
+	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
@@ -918,60 +902,53 @@ func runDHTGetValue(targetStr, key, pkPeerStr string, cfg *PeerConfig) {
 
 	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeClient))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT error: %v", err)
 	}
 	defer kadDHT.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
 	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT bootstrap error: %v", err)
 	}
 	time.Sleep(2 * time.Second)
 
-	// Build the actual key
-	actualKey := key
+	// Build the actual key; displayKey keeps /pk/ keys readable in events
+	actualKey, displayKey := key, key
 	if pkPeerStr != "" {
 		// Construct /pk/<raw-peer-id> from the base58 peer ID
 		pid, err := peer.Decode(pkPeerStr)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Invalid peer ID: %v\n", err)
-			os.Exit(1)
+			fatal("address", "Invalid peer ID: %v", err)
 		}
 		actualKey = "/pk/" + string(pid)
+		displayKey = "/pk/" + pid.String()
 	}
 
 	val, err := kadDHT.GetValue(ctx, actualKey)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "GetValue failed: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "GetValue failed: %v", err)
 	}
-	fmt.Printf("Value: %d bytes\n", len(val))
-	fmt.Println("Get successful")
+	emit(Event{Type: "dht_value", Key: displayKey, Bytes: len(val)}, "Value: %d bytes", len(val))
+	emit(Event{Type: "dht_get", Key: displayKey, Bytes: len(val)}, "Get successful")
 }
 
 // dht-provide mode: connect to target DHT peer and announce as provider
-func runDHTProvide(targetStr, cidStr string, cfg *PeerConfig) {
+func runDHTProvide(targetStr, cidStr, transport string, cfg *PeerConfig) {
 	if targetStr == "" || cidStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target and --cid required")
-		os.Exit(1)
+		fatal("usage", "Error: --target and --cid required")
 	}
 
-	h, err := createHost(0, "tcp", cfg)
+	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
@@ -980,52 +957,44 @@ func runDHTProvide(targetStr, cidStr string, cfg *PeerConfig) {
 
 	kadDHT, err := dht.New(ctx, h, dht.Mode(dht.ModeClient))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT error: %v", err)
 	}
 	defer kadDHT.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
 	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT bootstrap error: %v", err)
 	}
 	time.Sleep(2 * time.Second)
 
 	c, err := cid.Decode(cidStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid CID: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Invalid CID: %v", err)
 	}
 
 	if err := kadDHT.Provide(ctx, c, true); err != nil {
-		fmt.Fprintf(os.Stderr, "Provide failed: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "Provide failed: %v", err)
 	}
-	fmt.Println("Provide successful")
+	emit(Event{Type: "dht_provide", Key: c.String()}, "Provide successful")
 }
 
 // dht-find-providers mode: connect to target DHT peer and find providers for a CID
-func runDHTFindProviders(targetStr, cidStr string, cfg *PeerConfig) {
+func runDHTFindProviders(targetStr, cidStr, transport string, cfg *PeerConfig) {
 	if targetStr == "" || cidStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target and --cid required")
-		os.Exit(1)
+		fatal("usage", "Error: --target and --cid required")
 	}
 
-	h, err := createHost(0, "tcp", cfg)
+	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
@@ -1039,32 +1008,27 @@ func runDHTFindProviders(targetStr, cidStr string, cfg *PeerConfig) {
 		}),
 	)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "DHT error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT error: %v", err)
 	}
 	defer kadDHT.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
 
 	if err := kadDHT.Bootstrap(ctx); err != nil {
-		fmt.Fprintf(os.Stderr, "DHT bootstrap error: %v\n", err)
-		os.Exit(1)
+		fatal("dht", "DHT bootstrap error: %v", err)
 	}
 	time.Sleep(2 * time.Second)
 
 	c, err := cid.Decode(cidStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Invalid CID: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Invalid CID: %v", err)
 	}
 
 	fmt.Fprintf(os.Stderr, "Searching for providers of CID: %s (multihash: %s)\n", c, c.Hash())
@@ -1074,12 +1038,11 @@ func runDHTFindProviders(targetStr, cidStr string, cfg *PeerConfig) {
 		if prov.ID == "" {
 			continue
 		}
-		fmt.Printf("Provider: %s\n", prov.ID)
+		emit(Event{Type: "provider", Peer: prov.ID.String(), Key: c.String()}, "Provider: %s", prov.ID)
 		found = true
 	}
 	if !found {
-		fmt.Fprintln(os.Stderr, "No providers found")
-		os.Exit(1)
+		fatal("dht", "No providers found")
 	}
 }
 
@@ -1110,115 +1073,49 @@ func (t *debugTracer) UndeliverableMessage(msg *pubsub.Message) {
 }
 
 // pubsub-server mode: create GossipSub, subscribe to topic, print received messages
-func runPubSubServer(port int, topicName string, cfg *PeerConfig) {
-	h, err := createHost(port, "tcp", cfg)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
-	defer h.Close()
-
-	ctx := context.Background()
-	ps, err := pubsub.NewGossipSub(ctx, h,
-		pubsub.WithRawTracer(&debugTracer{}),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "GossipSub error: %v\n", err)
-		os.Exit(1)
-	}
-
-	topic, err := ps.Join(topicName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Join topic error: %v\n", err)
-		os.Exit(1)
-	}
-
-	sub, err := topic.Subscribe()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Subscribe error: %v\n", err)
-		os.Exit(1)
-	}
-
-	printHostInfo(h)
-
-	// Read messages in background
-	go func() {
-		for {
-			msg, err := sub.Next(ctx)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Sub.Next error: %v\n", err)
-				return
-			}
-			fmt.Fprintf(os.Stderr, "Got message from %s (receivedFrom: %s), data len: %d\n",
-				msg.GetFrom(), msg.ReceivedFrom, len(msg.Data))
-			// Skip our own messages
-			if msg.ReceivedFrom == h.ID() {
-				fmt.Fprintf(os.Stderr, "Skipping own message\n")
-				continue
-			}
-			fmt.Printf("Received: %s\n", string(msg.Data))
-		}
-	}()
-
-	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line == "quit" || line == "exit" {
-				os.Exit(0)
-			}
-		}
-	}()
-
-	waitForShutdown()
+func runPubSubServer(port int, transport, topicName string, cfg *PeerConfig) {
+	runServices(port, transport, []string{"ping", "pubsub"}, topicName, cfg)
 }
 
 // pubsub-client mode: connect to target, subscribe to topic, publish a message
-func runPubSubClient(targetStr, topicName, message string, cfg *PeerConfig) {
+func runPubSubClient(targetStr, topicName, message, transport string, cfg *PeerConfig) {
 	if targetStr == "" {
-		fmt.Fprintln(os.Stderr, "Error: --target required")
-		os.Exit(1)
+		fatal("usage", "Error: --target required")
 	}
 
-	h, err := createHost(0, "tcp", cfg)
+	h, err := createHost(0, transport, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("host", "Error: %v", err)
 	}
 	defer h.Close()
 
 	info, err := parseTarget(targetStr)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		fatal("address", "Error: %v", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := h.Connect(ctx, *info); err != nil {
-		fmt.Fprintf(os.Stderr, "Connection failed: %v\n", err)
-		os.Exit(1)
+		fatal("dial", "Connection failed: %v", err)
 	}
-	fmt.Println("Connected")
+	emit(Event{Type: "connected", Peer: info.ID.String()}, "Connected")
 
 	ps, err := pubsub.NewGossipSub(ctx, h)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "GossipSub error: %v\n", err)
-		os.Exit(1)
+		fatal("pubsub", "GossipSub error: %v", err)
 	}
 
 	topic, err := ps.Join(topicName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Join topic error: %v\n", err)
-		os.Exit(1)
+		fatal("pubsub", "Join topic error: %v", err)
 	}
 
 	// Subscribe first (required before publishing in GossipSub)
 	sub, err := topic.Subscribe()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Subscribe error: %v\n", err)
-		os.Exit(1)
+		fatal("pubsub", "Subscribe error: %v", err)
 	}
 	_ = sub
 
@@ -1227,13 +1124,12 @@ func runPubSubClient(targetStr, topicName, message string, cfg *PeerConfig) {
 
 	for i := 0; i < 5; i++ {
 		if err := topic.Publish(ctx, []byte(message)); err != nil {
-			fmt.Fprintf(os.Stderr, "Publish failed: %v\n", err)
-			os.Exit(1)
+			fatal("pubsub", "Publish failed: %v", err)
 		}
 		fmt.Fprintf(os.Stderr, "Published attempt %d: %s\n", i+1, message)
 		time.Sleep(2 * time.Second)
 	}
-	fmt.Printf("Published: %s\n", message)
-	fmt.Println("PubSub client done")
+	emit(Event{Type: "published", Topic: topicName, Message: message, Bytes: len(message)}, "Published: %s", message)
+	emit(Event{Type: "done"}, "PubSub client done")
 }
 
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// This file speaks the go-libp2p-daemon control protocol (p2pd.proto) over a
+// Unix socket. Messages are varint length-prefixed protobufs; the few messages
+// we need are encoded by hand with protowire rather than pulling in the
+// daemon's generated package.
+
+const (
+	p2pdDefaultTimeout = 60 * time.Second
+	p2pdMaxMessageSize = 4 << 20
+)
+
+// Request.Type
+const (
+	p2pdIdentify      = 0
+	p2pdConnect       = 1
+	p2pdStreamOpen    = 2
+	p2pdStreamHandler = 3
+	p2pdDHT           = 4
+	p2pdListPeers     = 5
+	p2pdConnManager   = 6
+	p2pdDisconnect    = 7
+	p2pdPubSub        = 8
+)
+
+// Response.Type
+const (
+	p2pdOK    = 0
+	p2pdError = 1
+)
+
+// DHTRequest.Type
+const (
+	p2pdDHTFindPeer                  = 0
+	p2pdDHTFindPeersConnectedToPeer  = 1
+	p2pdDHTFindProviders             = 2
+	p2pdDHTGetClosestPeers           = 3
+	p2pdDHTGetPublicKey              = 4
+	p2pdDHTGetValue                  = 5
+	p2pdDHTSearchValue               = 6
+	p2pdDHTPutValue                  = 7
+	p2pdDHTProvide                   = 8
+	p2pdDHTResponseBegin             = 0
+	p2pdDHTResponseValue             = 1
+	p2pdDHTResponseEnd               = 2
+	p2pdDHTDefaultFindProvidersCount = 20
+)
+
+// ConnManagerRequest.Type
+const (
+	p2pdTagPeer   = 0
+	p2pdUntagPeer = 1
+	p2pdTrim      = 2
+)
+
+// PSRequest.Type
+const (
+	p2pdPSGetTopics = 0
+	p2pdPSListPeers = 1
+	p2pdPSPublish   = 2
+	p2pdPSSubscribe = 3
+)
+
+// pbMessage is a decoded protobuf message, keyed by field number. Only the
+// varint and length-delimited wire types occur in p2pd.proto.
+type pbMessage struct {
+	varints map[protowire.Number]uint64
+	bytes   map[protowire.Number][][]byte
+}
+
+func parsePB(b []byte) (*pbMessage, error) {
+	m := &pbMessage{
+		varints: make(map[protowire.Number]uint64),
+		bytes:   make(map[protowire.Number][][]byte),
+	}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.varints[num] = v
+			b = b[n:]
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			m.bytes[num] = append(m.bytes[num], v)
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return m, nil
+}
+
+func (m *pbMessage) uint(num protowire.Number) uint64 { return m.varints[num] }
+
+func (m *pbMessage) has(num protowire.Number) bool {
+	_, ok := m.varints[num]
+	return ok || len(m.bytes[num]) > 0
+}
+
+func (m *pbMessage) bytesField(num protowire.Number) []byte {
+	if vs := m.bytes[num]; len(vs) > 0 {
+		return vs[len(vs)-1]
+	}
+	return nil
+}
+
+func (m *pbMessage) strings(num protowire.Number) []string {
+	var out []string
+	for _, v := range m.bytes[num] {
+		out = append(out, string(v))
+	}
+	return out
+}
+
+// message decodes an embedded message field; absent fields decode as empty.
+func (m *pbMessage) message(num protowire.Number) (*pbMessage, error) {
+	return parsePB(m.bytesField(num))
+}
+
+// pbBuilder appends protobuf fields to a message under construction.
+type pbBuilder []byte
+
+func (b *pbBuilder) varint(num protowire.Number, v uint64) {
+	*b = protowire.AppendTag(*b, num, protowire.VarintType)
+	*b = protowire.AppendVarint(*b, v)
+}
+
+func (b *pbBuilder) bytes(num protowire.Number, v []byte) {
+	*b = protowire.AppendTag(*b, num, protowire.BytesType)
+	*b = protowire.AppendBytes(*b, v)
+}
+
+func (b *pbBuilder) string(num protowire.Number, v string) {
+	*b = protowire.AppendTag(*b, num, protowire.BytesType)
+	*b = protowire.AppendString(*b, v)
+}
+
+func readDelimited(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > p2pdMaxMessageSize {
+		return nil, fmt.Errorf("message too large: %d bytes", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func writeDelimited(w io.Writer, msg []byte) error {
+	buf := binary.AppendUvarint(make([]byte, 0, len(msg)+binary.MaxVarintLen64), uint64(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+func okResponse() pbBuilder {
+	var b pbBuilder
+	b.varint(1, p2pdOK)
+	return b
+}
+
+func errorResponse(err error) []byte {
+	var e pbBuilder
+	e.string(1, err.Error())
+	var b pbBuilder
+	b.varint(1, p2pdError)
+	b.bytes(2, e)
+	return b
+}
+
+func peerInfoMessage(id peer.ID, addrs []multiaddr.Multiaddr) []byte {
+	var b pbBuilder
+	b.bytes(1, []byte(id))
+	for _, a := range addrs {
+		b.bytes(2, a.Bytes())
+	}
+	return b
+}
+
+func streamInfoMessage(s network.Stream) []byte {
+	var b pbBuilder
+	b.bytes(1, []byte(s.Conn().RemotePeer()))
+	b.bytes(2, s.Conn().RemoteMultiaddr().Bytes())
+	b.string(3, string(s.Protocol()))
+	return b
+}
+
+func dhtResponseMessage(typ uint64, fill func(b *pbBuilder)) []byte {
+	var b pbBuilder
+	b.varint(1, typ)
+	if fill != nil {
+		fill(&b)
+	}
+	return b
+}
+
+// requestTimeout reads the optional timeout field (seconds) of a request.
+func requestTimeout(m *pbMessage, num protowire.Number) time.Duration {
+	if t := int64(m.uint(num)); t > 0 {
+		return time.Duration(t) * time.Second
+	}
+	return p2pdDefaultTimeout
+}
+
+// p2pdServer serves control connections for one host.
+type p2pdServer struct {
+	h      host.Host
+	kadDHT *dht.IpfsDHT
+	ps     *pubsub.PubSub
+
+	topicsMu sync.Mutex
+	topics   map[string]*pubsub.Topic
+}
+
+// p2pd mode: serve the go-libp2p-daemon control protocol on a Unix socket
+func runP2PD(port int, transport, socketPath string, cfg *PeerConfig) {
+	h, err := createHost(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	ctx := context.Background()
+	kadDHT, err := newServerDHT(ctx, h)
+	if err != nil {
+		fatal("dht", "DHT error: %v", err)
+	}
+	defer kadDHT.Close()
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		fatal("pubsub", "GossipSub error: %v", err)
+	}
+
+	os.Remove(socketPath)
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		fatal("config", "Control socket error: %v", err)
+	}
+	defer os.Remove(socketPath)
+	defer l.Close()
+
+	srv := &p2pdServer{h: h, kadDHT: kadDHT, ps: ps, topics: make(map[string]*pubsub.Topic)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConn(c)
+		}
+	}()
+
+	emit(Event{Type: "control_socket", Addrs: []string{"/unix" + socketPath}}, "ControlSocket: %s", socketPath)
+	printHostInfo(h)
+
+	watchStdinQuit(func() { os.Remove(socketPath) })
+
+	waitForShutdown()
+}
+
+// handleConn reads requests until the client hangs up or the connection is
+// handed over to a stream or subscription.
+func (srv *p2pdServer) handleConn(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		raw, err := readDelimited(r)
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintf(os.Stderr, "p2pd read error: %v\n", err)
+			}
+			return
+		}
+		req, err := parsePB(raw)
+		if err != nil {
+			writeDelimited(c, errorResponse(fmt.Errorf("malformed request: %w", err)))
+			return
+		}
+
+		switch req.uint(1) {
+		case p2pdIdentify:
+			writeDelimited(c, srv.identify())
+		case p2pdConnect:
+			writeDelimited(c, srv.connect(req))
+		case p2pdStreamOpen:
+			res, s := srv.streamOpen(req)
+			writeDelimited(c, res)
+			if s != nil {
+				pipeStream(c, r, s)
+				return
+			}
+		case p2pdStreamHandler:
+			writeDelimited(c, srv.streamHandler(req))
+		case p2pdDHT:
+			if !srv.dht(c, req) {
+				return
+			}
+		case p2pdListPeers:
+			writeDelimited(c, srv.listPeers())
+		case p2pdConnManager:
+			writeDelimited(c, srv.connManager(req))
+		case p2pdDisconnect:
+			writeDelimited(c, srv.disconnect(req))
+		case p2pdPubSub:
+			res, sub := srv.pubsub(req)
+			writeDelimited(c, res)
+			if sub != nil {
+				pipeSubscription(c, r, sub)
+				return
+			}
+		default:
+			writeDelimited(c, errorResponse(fmt.Errorf("unsupported request type %d", req.uint(1))))
+		}
+	}
+}
+
+func (srv *p2pdServer) identify() []byte {
+	var id pbBuilder
+	id.bytes(1, []byte(srv.h.ID()))
+	for _, a := range srv.h.Addrs() {
+		id.bytes(2, a.Bytes())
+	}
+	res := okResponse()
+	res.bytes(4, id)
+	return res
+}
+
+func (srv *p2pdServer) connect(req *pbMessage) []byte {
+	m, err := req.message(2)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pid, err := peer.IDFromBytes(m.bytesField(1))
+	if err != nil {
+		return errorResponse(err)
+	}
+	var addrs []multiaddr.Multiaddr
+	for _, b := range m.bytes[2] {
+		a, err := multiaddr.NewMultiaddrBytes(b)
+		if err != nil {
+			return errorResponse(err)
+		}
+		addrs = append(addrs, a)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(m, 3))
+	defer cancel()
+	if err := srv.h.Connect(ctx, peer.AddrInfo{ID: pid, Addrs: addrs}); err != nil {
+		return errorResponse(err)
+	}
+	return okResponse()
+}
+
+func (srv *p2pdServer) streamOpen(req *pbMessage) ([]byte, network.Stream) {
+	m, err := req.message(3)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	pid, err := peer.IDFromBytes(m.bytesField(1))
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	var protos []protocol.ID
+	for _, p := range m.strings(2) {
+		protos = append(protos, protocol.ID(p))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(m, 3))
+	defer cancel()
+	s, err := srv.h.NewStream(network.WithAllowLimitedConn(ctx, "p2pd"), pid, protos...)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	res := okResponse()
+	res.bytes(3, streamInfoMessage(s))
+	return res, s
+}
+
+// streamHandler registers protocols whose inbound streams are forwarded to a
+// socket the client listens on, prefixed with a StreamInfo message.
+func (srv *p2pdServer) streamHandler(req *pbMessage) []byte {
+	m, err := req.message(4)
+	if err != nil {
+		return errorResponse(err)
+	}
+	addr, err := multiaddr.NewMultiaddrBytes(m.bytesField(1))
+	if err != nil {
+		return errorResponse(err)
+	}
+	for _, p := range m.strings(2) {
+		srv.h.SetStreamHandler(protocol.ID(p), func(s network.Stream) {
+			c, err := manet.Dial(addr)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "p2pd handler dial %s: %v\n", addr, err)
+				s.Reset()
+				return
+			}
+			defer c.Close()
+			if err := writeDelimited(c, streamInfoMessage(s)); err != nil {
+				s.Reset()
+				return
+			}
+			pipeStream(c, bufio.NewReader(c), s)
+		})
+	}
+	return okResponse()
+}
+
+// pipeStream copies between a control connection and a libp2p stream until
+// both directions finish.
+func pipeStream(c net.Conn, r io.Reader, s network.Stream) {
+	done := make(chan struct{})
+	go func() {
+		io.Copy(s, r)
+		s.CloseWrite()
+		close(done)
+	}()
+	io.Copy(c, s)
+	if cw, ok := c.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	<-done
+	s.Close()
+}
+
+// dht handles a DHT request. It returns false if the connection should be
+// closed afterwards.
+func (srv *p2pdServer) dht(c net.Conn, req *pbMessage) bool {
+	m, err := req.message(5)
+	if err != nil {
+		return writeDelimited(c, errorResponse(err)) == nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout(m, 7))
+	defer cancel()
+
+	single := func(fill func(b *pbBuilder)) bool {
+		res := okResponse()
+		res.bytes(5, dhtResponseMessage(p2pdDHTResponseValue, fill))
+		return writeDelimited(c, res) == nil
+	}
+	// stream writes BEGIN, one VALUE per item, then END.
+	stream := func(run func(send func(fill func(b *pbBuilder)) error) error) bool {
+		res := okResponse()
+		res.bytes(5, dhtResponseMessage(p2pdDHTResponseBegin, nil))
+		if writeDelimited(c, res) != nil {
+			return false
+		}
+		err := run(func(fill func(b *pbBuilder)) error {
+			return writeDelimited(c, dhtResponseMessage(p2pdDHTResponseValue, fill))
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "p2pd DHT stream error: %v\n", err)
+		}
+		return writeDelimited(c, dhtResponseMessage(p2pdDHTResponseEnd, nil)) == nil
+	}
+
+	switch m.uint(1) {
+	case p2pdDHTFindPeer:
+		pid, err := peer.IDFromBytes(m.bytesField(2))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		info, err := srv.kadDHT.FindPeer(ctx, pid)
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return single(func(b *pbBuilder) { b.bytes(2, peerInfoMessage(info.ID, info.Addrs)) })
+
+	case p2pdDHTFindProviders:
+		cidv, err := cid.Cast(m.bytesField(3))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		count := int(m.uint(6))
+		if count <= 0 {
+			count = p2pdDHTDefaultFindProvidersCount
+		}
+		return stream(func(send func(func(b *pbBuilder)) error) error {
+			for prov := range srv.kadDHT.FindProvidersAsync(ctx, cidv, count) {
+				if err := send(func(b *pbBuilder) { b.bytes(2, peerInfoMessage(prov.ID, prov.Addrs)) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case p2pdDHTGetClosestPeers:
+		key := string(m.bytesField(4))
+		return stream(func(send func(func(b *pbBuilder)) error) error {
+			peers, err := srv.kadDHT.GetClosestPeers(ctx, key)
+			if err != nil {
+				return err
+			}
+			for _, p := range peers {
+				if err := send(func(b *pbBuilder) { b.bytes(3, []byte(p)) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case p2pdDHTGetPublicKey:
+		pid, err := peer.IDFromBytes(m.bytesField(2))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		pk, err := srv.kadDHT.GetPublicKey(ctx, pid)
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		pkBytes, err := crypto.MarshalPublicKey(pk)
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return single(func(b *pbBuilder) { b.bytes(3, pkBytes) })
+
+	case p2pdDHTGetValue:
+		val, err := srv.kadDHT.GetValue(ctx, string(m.bytesField(4)))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return single(func(b *pbBuilder) { b.bytes(3, val) })
+
+	case p2pdDHTSearchValue:
+		ch, err := srv.kadDHT.SearchValue(ctx, string(m.bytesField(4)))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return stream(func(send func(func(b *pbBuilder)) error) error {
+			for val := range ch {
+				if err := send(func(b *pbBuilder) { b.bytes(3, val) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+	case p2pdDHTPutValue:
+		if err := srv.kadDHT.PutValue(ctx, string(m.bytesField(4)), m.bytesField(5)); err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return writeDelimited(c, okResponse()) == nil
+
+	case p2pdDHTProvide:
+		cidv, err := cid.Cast(m.bytesField(3))
+		if err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		if err := srv.kadDHT.Provide(ctx, cidv, true); err != nil {
+			return writeDelimited(c, errorResponse(err)) == nil
+		}
+		return writeDelimited(c, okResponse()) == nil
+
+	default:
+		return writeDelimited(c, errorResponse(fmt.Errorf("unsupported DHT request type %d", m.uint(1)))) == nil
+	}
+}
+
+func (srv *p2pdServer) listPeers() []byte {
+	res := okResponse()
+	for _, c := range srv.h.Network().Conns() {
+		res.bytes(6, peerInfoMessage(c.RemotePeer(), []multiaddr.Multiaddr{c.RemoteMultiaddr()}))
+	}
+	return res
+}
+
+func (srv *p2pdServer) connManager(req *pbMessage) []byte {
+	m, err := req.message(6)
+	if err != nil {
+		return errorResponse(err)
+	}
+	cm := srv.h.ConnManager()
+	switch m.uint(1) {
+	case p2pdTagPeer, p2pdUntagPeer:
+		pid, err := peer.IDFromBytes(m.bytesField(2))
+		if err != nil {
+			return errorResponse(err)
+		}
+		tag := string(m.bytesField(3))
+		if tag == "" {
+			return errorResponse(errors.New("missing tag"))
+		}
+		if m.uint(1) == p2pdTagPeer {
+			cm.TagPeer(pid, tag, int(int64(m.uint(4))))
+		} else {
+			cm.UntagPeer(pid, tag)
+		}
+	case p2pdTrim:
+		ctx, cancel := context.WithTimeout(context.Background(), p2pdDefaultTimeout)
+		defer cancel()
+		cm.TrimOpenConns(ctx)
+	default:
+		return errorResponse(fmt.Errorf("unsupported connmanager request type %d", m.uint(1)))
+	}
+	return okResponse()
+}
+
+func (srv *p2pdServer) disconnect(req *pbMessage) []byte {
+	m, err := req.message(7)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pid, err := peer.IDFromBytes(m.bytesField(1))
+	if err != nil {
+		return errorResponse(err)
+	}
+	if err := srv.h.Network().ClosePeer(pid); err != nil {
+		return errorResponse(err)
+	}
+	return okResponse()
+}
+
+func (srv *p2pdServer) topic(name string) (*pubsub.Topic, error) {
+	srv.topicsMu.Lock()
+	defer srv.topicsMu.Unlock()
+	if t, ok := srv.topics[name]; ok {
+		return t, nil
+	}
+	t, err := srv.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	srv.topics[name] = t
+	return t, nil
+}
+
+// pubsub handles a PSRequest. For SUBSCRIBE it also returns the subscription
+// that the connection should be switched over to.
+func (srv *p2pdServer) pubsub(req *pbMessage) ([]byte, *pubsub.Subscription) {
+	m, err := req.message(8)
+	if err != nil {
+		return errorResponse(err), nil
+	}
+	topicName := string(m.bytesField(2))
+
+	switch m.uint(1) {
+	case p2pdPSGetTopics:
+		var psr pbBuilder
+		for _, t := range srv.ps.GetTopics() {
+			psr.string(1, t)
+		}
+		res := okResponse()
+		res.bytes(7, psr)
+		return res, nil
+
+	case p2pdPSListPeers:
+		var psr pbBuilder
+		for _, p := range srv.ps.ListPeers(topicName) {
+			psr.bytes(2, []byte(p))
+		}
+		res := okResponse()
+		res.bytes(7, psr)
+		return res, nil
+
+	case p2pdPSPublish:
+		if !m.has(2) {
+			return errorResponse(errors.New("missing topic")), nil
+		}
+		t, err := srv.topic(topicName)
+		if err != nil {
+			return errorResponse(err), nil
+		}
+		if err := t.Publish(context.Background(), m.bytesField(3)); err != nil {
+			return errorResponse(err), nil
+		}
+		return okResponse(), nil
+
+	case p2pdPSSubscribe:
+		if !m.has(2) {
+			return errorResponse(errors.New("missing topic")), nil
+		}
+		t, err := srv.topic(topicName)
+		if err != nil {
+			return errorResponse(err), nil
+		}
+		sub, err := t.Subscribe()
+		if err != nil {
+			return errorResponse(err), nil
+		}
+		return okResponse(), sub
+	}
+	return errorResponse(fmt.Errorf("unsupported pubsub request type %d", m.uint(1))), nil
+}
+
+// pipeSubscription streams PSMessages to the client until it disconnects.
+func pipeSubscription(c net.Conn, r io.Reader, sub *pubsub.Subscription) {
+	defer sub.Cancel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		// The client never sends anything else; EOF means it went away.
+		io.Copy(io.Discard, r)
+		cancel()
+	}()
+
+	for {
+		msg, err := sub.Next(ctx)
+		if err != nil {
+			return
+		}
+		var b pbBuilder
+		b.bytes(1, []byte(msg.GetFrom()))
+		b.bytes(2, msg.Data)
+		b.bytes(3, msg.GetSeqno())
+		b.string(4, msg.GetTopic())
+		if sig := msg.GetSignature(); len(sig) > 0 {
+			b.bytes(5, sig)
+		}
+		if key := msg.GetKey(); len(key) > 0 {
+			b.bytes(6, key)
+		}
+		if err := writeDelimited(c, b); err != nil {
+			return
+		}
+	}
+}
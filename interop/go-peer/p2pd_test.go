@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newTestHost(t *testing.T) host.Host {
+	t.Helper()
+	h, err := createHost(0, "tcp", &PeerConfig{})
+	if err != nil {
+		t.Fatalf("createHost: %v", err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+// p2pdClient is a control connection to a p2pdServer, speaking the protocol
+// the way go-libp2p-daemon's p2pclient does.
+type p2pdClient struct {
+	t *testing.T
+	c net.Conn
+	r *bufio.Reader
+}
+
+// startP2PD serves a p2pdServer for h on a Unix socket and connects to it.
+func startP2PD(t *testing.T, h host.Host) *p2pdClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "p2pd.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	srv := &p2pdServer{h: h}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConn(c)
+		}
+	}()
+
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial control socket: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	return &p2pdClient{t: t, c: c, r: bufio.NewReader(c)}
+}
+
+// request sends a Request of typ with its sub-message in field num (none if
+// num is 0) and returns the decoded Response.
+func (cl *p2pdClient) request(typ uint64, num protowire.Number, sub pbBuilder) *pbMessage {
+	cl.t.Helper()
+	var req pbBuilder
+	req.varint(1, typ)
+	if num != 0 {
+		req.bytes(num, sub)
+	}
+	if err := writeDelimited(cl.c, req); err != nil {
+		cl.t.Fatalf("write request: %v", err)
+	}
+	return cl.read()
+}
+
+func (cl *p2pdClient) read() *pbMessage {
+	cl.t.Helper()
+	raw, err := readDelimited(cl.r)
+	if err != nil {
+		cl.t.Fatalf("read response: %v", err)
+	}
+	m, err := parsePB(raw)
+	if err != nil {
+		cl.t.Fatalf("parse response: %v", err)
+	}
+	return m
+}
+
+func requireOK(t *testing.T, res *pbMessage) {
+	t.Helper()
+	if res.uint(1) != p2pdOK {
+		e, _ := res.message(2)
+		t.Fatalf("response type %d, error %q", res.uint(1), e.bytesField(1))
+	}
+}
+
+func connectRequest(h host.Host) pbBuilder {
+	var b pbBuilder
+	b.bytes(1, []byte(h.ID()))
+	for _, a := range h.Addrs() {
+		b.bytes(2, a.Bytes())
+	}
+	return b
+}
+
+func TestPBRoundTrip(t *testing.T) {
+	var inner pbBuilder
+	inner.string(1, "nested")
+	var b pbBuilder
+	b.varint(1, 300)
+	b.bytes(2, []byte{0, 1, 2})
+	b.string(3, "a")
+	b.string(3, "b")
+	b.bytes(4, inner)
+	b = protowire.AppendTag(b, 5, protowire.Fixed64Type) // skipped unknown field
+	b = protowire.AppendFixed64(b, 7)
+
+	var buf bytes.Buffer
+	if err := writeDelimited(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := readDelimited(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := parsePB(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.uint(1) != 300 || !m.has(1) || m.has(6) {
+		t.Errorf("varint field: got %d", m.uint(1))
+	}
+	if !bytes.Equal(m.bytesField(2), []byte{0, 1, 2}) {
+		t.Errorf("bytes field: got %x", m.bytesField(2))
+	}
+	if got := m.strings(3); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("repeated field: got %q", got)
+	}
+	sub, err := m.message(4)
+	if err != nil || string(sub.bytesField(1)) != "nested" {
+		t.Errorf("embedded message: got %q, %v", sub.bytesField(1), err)
+	}
+}
+
+func TestPBMalformed(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"truncated varint": {0x08, 0x80},
+		"truncated bytes":  {0x12, 0x05, 'a'},
+		"bad tag":          {0x80},
+	} {
+		if _, err := parsePB(b); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(protowire.AppendVarint(nil, p2pdMaxMessageSize+1))
+	if _, err := readDelimited(bufio.NewReader(&buf)); err == nil {
+		t.Error("oversized message: no error")
+	}
+}
+
+func TestP2PDIdentify(t *testing.T) {
+	h := newTestHost(t)
+	cl := startP2PD(t, h)
+
+	res := cl.request(p2pdIdentify, 0, nil)
+	requireOK(t, res)
+	id, err := res.message(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := peer.IDFromBytes(id.bytesField(1))
+	if err != nil || pid != h.ID() {
+		t.Fatalf("identify peer: got %s, %v, want %s", pid, err, h.ID())
+	}
+	if len(id.bytes[2]) != len(h.Addrs()) {
+		t.Fatalf("identify addrs: got %d, want %d", len(id.bytes[2]), len(h.Addrs()))
+	}
+	for _, b := range id.bytes[2] {
+		if _, err := multiaddr.NewMultiaddrBytes(b); err != nil {
+			t.Errorf("identify addr %x: %v", b, err)
+		}
+	}
+}
+
+func TestP2PDUnsupported(t *testing.T) {
+	cl := startP2PD(t, newTestHost(t))
+	res := cl.request(42, 0, nil)
+	if res.uint(1) != p2pdError {
+		t.Fatalf("response type %d, want error", res.uint(1))
+	}
+}
+
+func TestP2PDConnect(t *testing.T) {
+	h, remote := newTestHost(t), newTestHost(t)
+	cl := startP2PD(t, h)
+
+	requireOK(t, cl.request(p2pdConnect, 2, connectRequest(remote)))
+	if h.Network().Connectedness(remote.ID()) != network.Connected {
+		t.Fatal("not connected after CONNECT")
+	}
+}
+
+func TestP2PDStreamOpen(t *testing.T) {
+	h, remote := newTestHost(t), newTestHost(t)
+	remote.SetStreamHandler(echoProtocol, echoHandler(false))
+	cl := startP2PD(t, h)
+	requireOK(t, cl.request(p2pdConnect, 2, connectRequest(remote)))
+
+	var open pbBuilder
+	open.bytes(1, []byte(remote.ID()))
+	open.string(2, echoProtocol)
+	res := cl.request(p2pdStreamOpen, 3, open)
+	requireOK(t, res)
+	info, err := res.message(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := peer.IDFromBytes(info.bytesField(1)); pid != remote.ID() {
+		t.Errorf("stream info peer: got %s, want %s", pid, remote.ID())
+	}
+	if _, err := multiaddr.NewMultiaddrBytes(info.bytesField(2)); err != nil {
+		t.Errorf("stream info addr: %v", err)
+	}
+	if p := string(info.bytesField(3)); p != echoProtocol {
+		t.Errorf("stream info protocol: got %q", p)
+	}
+
+	// The control connection is now the stream.
+	if _, err := cl.c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(cl.r, got); err != nil || string(got) != "hello" {
+		t.Fatalf("echo: got %q, %v", got, err)
+	}
+}
+
+func TestP2PDStreamHandler(t *testing.T) {
+	h, remote := newTestHost(t), newTestHost(t)
+	cl := startP2PD(t, h)
+
+	// The client's handler socket, given to the daemon as a binary multiaddr
+	// as p2pclient does.
+	path := filepath.Join(t.TempDir(), "handler.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr, err := multiaddr.NewMultiaddr("/unix" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const proto = "/p2pd-test/1.0.0"
+	var reg pbBuilder
+	reg.bytes(1, addr.Bytes())
+	reg.string(2, proto)
+	requireOK(t, cl.request(p2pdStreamHandler, 4, reg))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := remote.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := remote.NewStream(ctx, h.ID(), proto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := s.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(c)
+	raw, err := readDelimited(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := parsePB(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid, _ := peer.IDFromBytes(info.bytesField(1)); pid != remote.ID() {
+		t.Errorf("stream info peer: got %s, want %s", pid, remote.ID())
+	}
+	if p := string(info.bytesField(3)); p != proto {
+		t.Errorf("stream info protocol: got %q", p)
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(r, got); err != nil || string(got) != "ping" {
+		t.Fatalf("handler read: got %q, %v", got, err)
+	}
+	if _, err := c.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(s, got); err != nil || string(got) != "pong" {
+		t.Fatalf("stream read: got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// perfProtocol is the libp2p perf protocol: the client sends the number of
+// bytes it wants as a big-endian uint64, uploads until it closes its write
+// side, then reads the requested bytes until the server closes.
+const perfProtocol = "/perf/1.0.0"
+
+// perfBlockSize is the write and read size of both perf sides.
+const perfBlockSize = 64 * 1024
+
+// PerfStats is the result of one perf run, reported as the perf field of
+// perf_result events. Setup is opening the perf stream; the upload is timed
+// from there. TTFB runs from the end of the upload to the first downloaded
+// byte.
+type PerfStats struct {
+	ConnectMs     float64 `json:"connect_ms"`
+	SetupMs       float64 `json:"setup_ms"`
+	UploadBytes   uint64  `json:"upload_bytes"`
+	UploadMs      float64 `json:"upload_ms"`
+	UploadMbps    float64 `json:"upload_mbps"`
+	DownloadBytes uint64  `json:"download_bytes"`
+	DownloadMs    float64 `json:"download_ms"`
+	DownloadMbps  float64 `json:"download_mbps"`
+	TTFBMs        float64 `json:"ttfb_ms"`
+	TotalMs       float64 `json:"total_ms"`
+}
+
+// mbps is the throughput of n bytes in d in megabits per second, the unit of
+// the published libp2p perf results.
+func mbps(n uint64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) * 8 / d.Seconds() / 1e6
+}
+
+// perfHandler serves /perf/1.0.0: it discards the upload and then sends the
+// number of bytes the client asked for.
+func perfHandler(s network.Stream) {
+	defer s.Close()
+	p := s.Conn().RemotePeer().String()
+	var hdr [8]byte
+	if _, err := io.ReadFull(s, hdr[:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Perf read error: %v\n", err)
+		s.Reset()
+		return
+	}
+	want := binary.BigEndian.Uint64(hdr[:])
+
+	start := time.Now()
+	up, err := io.CopyBuffer(io.Discard, s, make([]byte, perfBlockSize))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Perf read error: %v\n", err)
+		s.Reset()
+		return
+	}
+	uploaded := time.Since(start)
+
+	if _, err := writeZeros(s, want); err != nil {
+		fmt.Fprintf(os.Stderr, "Perf write error: %v\n", err)
+		s.Reset()
+		return
+	}
+	msg := fmt.Sprintf("received %d bytes in %s, sent %d bytes in %s", up, uploaded.Round(time.Millisecond),
+		want, (time.Since(start) - uploaded).Round(time.Millisecond))
+	emit(Event{Type: "perf_served", Peer: p, Protocol: perfProtocol, Bytes: int(want), Message: msg}, "PerfServed: %s peer=%s", msg, p)
+}
+
+// writeZeros writes n zero bytes to w in perfBlockSize writes.
+func writeZeros(w io.Writer, n uint64) (uint64, error) {
+	buf := make([]byte, perfBlockSize)
+	var sent uint64
+	for sent < n {
+		k, err := w.Write(buf[:min(uint64(len(buf)), n-sent)])
+		sent += uint64(k)
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// perf-server mode: serve /perf/1.0.0
+func runPerfServer(port int, transport string, cfg *PeerConfig) {
+	runServices(port, transport, []string{"perf"}, "", cfg)
+}
+
+// perf-client mode: upload uploadBytes and download downloadBytes on one
+// /perf/1.0.0 stream and report the throughput of each direction
+func runPerfClient(targetStr, transport string, uploadBytes, downloadBytes uint64, cfg *PeerConfig) {
+	if targetStr == "" {
+		fatal("usage", "Error: --target required")
+	}
+
+	h, err := createHost(0, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	info, err := parseTarget(targetStr)
+	if err != nil {
+		fatal("address", "Error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := h.Connect(ctx, *info); err != nil {
+		fatal("dial", "Connection failed: %v", err)
+	}
+	connected := time.Now()
+
+	s, err := h.NewStream(ctx, info.ID, protocol.ID(perfProtocol))
+	if err != nil {
+		fatal("stream", "Stream failed: %v", err)
+	}
+	defer s.Close()
+	opened := time.Now()
+
+	var hdr [8]byte
+	binary.BigEndian.PutUint64(hdr[:], downloadBytes)
+	if _, err := s.Write(hdr[:]); err != nil {
+		fatal("io", "Write failed: %v", err)
+	}
+	if _, err := writeZeros(s, uploadBytes); err != nil {
+		fatal("io", "Upload failed: %v", err)
+	}
+	if err := s.CloseWrite(); err != nil {
+		fatal("io", "Close write failed: %v", err)
+	}
+	uploaded := time.Now()
+
+	// Time the first byte apart from the rest of the download.
+	buf := make([]byte, perfBlockSize)
+	var got uint64
+	var firstByte time.Time
+	for {
+		n, err := s.Read(buf)
+		if n > 0 && firstByte.IsZero() {
+			firstByte = time.Now()
+		}
+		got += uint64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fatal("io", "Download failed after %d bytes: %v", got, err)
+		}
+	}
+	done := time.Now()
+	if got != downloadBytes {
+		fatal("mismatch", "Perf: downloaded %d bytes, requested %d", got, downloadBytes)
+	}
+	if firstByte.IsZero() {
+		firstByte = done
+	}
+
+	st := PerfStats{
+		ConnectMs:     msec(connected.Sub(start)),
+		SetupMs:       msec(opened.Sub(connected)),
+		UploadBytes:   uploadBytes,
+		UploadMs:      msec(uploaded.Sub(opened)),
+		UploadMbps:    mbps(uploadBytes, uploaded.Sub(opened)),
+		DownloadBytes: got,
+		DownloadMs:    msec(done.Sub(uploaded)),
+		DownloadMbps:  mbps(got, done.Sub(uploaded)),
+		TTFBMs:        msec(firstByte.Sub(uploaded)),
+		TotalMs:       msec(done.Sub(start)),
+	}
+	emit(Event{Type: "perf_result", Peer: info.ID.String(), Protocol: perfProtocol, Perf: &st},
+		"Perf: upload %d bytes in %.2fms (%.2f Mbit/s), download %d bytes in %.2fms (%.2f Mbit/s), ttfb=%.2fms connect=%.2fms setup=%.2fms total=%.2fms",
+		st.UploadBytes, st.UploadMs, st.UploadMbps, st.DownloadBytes, st.DownloadMs, st.DownloadMbps, st.TTFBMs, st.ConnectMs, st.SetupMs, st.TotalMs)
+}
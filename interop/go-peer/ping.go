@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mrand "math/rand/v2"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// pingProtocol is the libp2p ping protocol: the remote echoes every 32-byte
+// ping back on the same stream.
+const pingProtocol = "/ipfs/ping/1.0.0"
+
+// pingSize is the payload of every ping and pong.
+const pingSize = 32
+
+// PingStats summarises a multi-round ping, reported as the ping_stats field
+// of ping_stats events. RTTs cover the pings that were answered.
+type PingStats struct {
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	LossPct  float64 `json:"loss_pct"`
+	MinMs    float64 `json:"min_ms"`
+	AvgMs    float64 `json:"avg_ms"`
+	MaxMs    float64 `json:"max_ms"`
+	StddevMs float64 `json:"stddev_ms"`
+}
+
+func newPingStats(sent int, rtts []time.Duration) PingStats {
+	st := PingStats{Sent: sent, Received: len(rtts)}
+	if sent > 0 {
+		st.LossPct = float64(sent-len(rtts)) * 100 / float64(sent)
+	}
+	if len(rtts) == 0 {
+		return st
+	}
+	var sum float64
+	for _, rtt := range rtts {
+		sum += msec(rtt)
+	}
+	st.AvgMs = sum / float64(len(rtts))
+	var sq float64
+	for _, rtt := range rtts {
+		d := msec(rtt) - st.AvgMs
+		sq += d * d
+	}
+	st.StddevMs = math.Sqrt(sq / float64(len(rtts)))
+	st.MinMs, st.MaxMs = msec(slices.Min(rtts)), msec(slices.Max(rtts))
+	return st
+}
+
+// pinger pings one peer, on a fresh stream each time or on one reused
+// stream.
+type pinger struct {
+	h     host.Host
+	p     peer.ID
+	reuse bool
+	s     network.Stream // the reused stream, nil until opened
+}
+
+// ping sends one ping and waits for its pong until ctx is done; opening a
+// fresh stream counts against the same deadline. A reused stream is reset
+// after a failure, since a late pong would answer the next ping, and the
+// next ping opens a new one.
+func (pg *pinger) ping(ctx context.Context) (time.Duration, error) {
+	s := pg.s
+	if s == nil {
+		var err error
+		if s, err = pg.h.NewStream(ctx, pg.p, pingProtocol); err != nil {
+			return 0, fmt.Errorf("ping stream: %w", err)
+		}
+	}
+	rtt, err := pingOn(ctx, s)
+	switch {
+	case err != nil:
+		s.Reset()
+		pg.s = nil
+	case pg.reuse:
+		pg.s = s
+	default:
+		s.Close()
+	}
+	return rtt, err
+}
+
+func (pg *pinger) close() {
+	if pg.s != nil {
+		pg.s.Close()
+	}
+}
+
+// pingOn sends a random ping on s and reads back the pong before ctx's
+// deadline.
+func pingOn(ctx context.Context, s network.Stream) (time.Duration, error) {
+	data := make([]byte, pingSize)
+	rand.Read(data)
+
+	deadline, _ := ctx.Deadline()
+	s.SetDeadline(deadline)
+	start := time.Now()
+	if _, err := s.Write(data); err != nil {
+		return 0, fmt.Errorf("ping write: %w", err)
+	}
+	resp := make([]byte, pingSize)
+	if _, err := io.ReadFull(s, resp); err != nil {
+		return 0, fmt.Errorf("ping read: %w", err)
+	}
+	rtt := time.Since(start)
+
+	if !bytes.Equal(data, resp) {
+		return 0, errors.New("data mismatch")
+	}
+	return rtt, nil
+}
+
+// pingRounds pings p count times, interval apart, and reports each ping and,
+// for more than one, the statistics. A single ping reports like it always
+// has. It fails only if no ping was answered.
+func pingRounds(h host.Host, p peer.ID, count int, interval time.Duration, reuse bool, timeout time.Duration) {
+	pg := &pinger{h: h, p: p, reuse: reuse}
+	defer pg.close()
+
+	var rtts []time.Duration
+	start := time.Now()
+	for i := range count {
+		time.Sleep(time.Until(start.Add(time.Duration(i) * interval)))
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		rtt, err := pg.ping(ctx)
+		cancel()
+		if err != nil {
+			if count == 1 {
+				fatal("ping", "Ping failed: %v", err)
+			}
+			emit(Event{Type: "ping_lost", Peer: p.String(), Message: fmt.Sprintf("seq=%d: %v", i+1, err)},
+				"Ping lost: seq=%d: %v", i+1, err)
+			continue
+		}
+		rtts = append(rtts, rtt)
+		if count == 1 {
+			emit(Event{Type: "ping", Peer: p.String(), RTTMs: msec(rtt)}, "Ping successful: rtt=%v", rtt)
+		} else {
+			emit(Event{Type: "ping", Peer: p.String(), RTTMs: msec(rtt), Message: fmt.Sprintf("seq=%d", i+1)},
+				"Ping successful: rtt=%v seq=%d", rtt, i+1)
+		}
+	}
+	if count == 1 {
+		return
+	}
+
+	st := newPingStats(count, rtts)
+	emit(Event{Type: "ping_stats", Peer: p.String(), RTTMs: st.AvgMs, PingStats: &st},
+		"PingStats: sent=%d received=%d loss=%.1f%% rtt min/avg/max/stddev=%.3f/%.3f/%.3f/%.3fms",
+		st.Sent, st.Received, st.LossPct, st.MinMs, st.AvgMs, st.MaxMs, st.StddevMs)
+	if st.Received == 0 {
+		fatal("ping", "Ping failed: all %d pings lost", count)
+	}
+}
+
+// startPingDelay replaces the host's ping service with one that holds every
+// pong for the configured delay plus a random jitter. Hosts that don't serve
+// ping are left alone.
+func startPingDelay(h host.Host, cfg *PeerConfig) {
+	if cfg == nil || (cfg.Ping.Delay == 0 && cfg.Ping.Jitter == 0) {
+		return
+	}
+	if !slices.Contains(h.Mux().Protocols(), protocol.ID(pingProtocol)) {
+		return
+	}
+	delay, jitter := time.Duration(cfg.Ping.Delay), time.Duration(cfg.Ping.Jitter)
+	h.SetStreamHandler(pingProtocol, func(s network.Stream) {
+		defer s.Close()
+		p := s.Conn().RemotePeer().String()
+		buf := make([]byte, pingSize)
+		for {
+			if _, err := io.ReadFull(s, buf); err != nil {
+				if err != io.EOF {
+					fmt.Fprintf(os.Stderr, "Ping read error: %v\n", err)
+				}
+				return
+			}
+			d := delay
+			if jitter > 0 {
+				d += mrand.N(jitter)
+			}
+			time.Sleep(d)
+			if _, err := s.Write(buf); err != nil {
+				fmt.Fprintf(os.Stderr, "Ping write error: %v\n", err)
+				s.Reset()
+				return
+			}
+			emit(Event{Type: "ping_delayed", Peer: p, DelayMs: msec(d)}, "PingDelayed: %dms peer=%s", d.Milliseconds(), p)
+		}
+	})
+}
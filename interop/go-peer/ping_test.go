@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewPingStats(t *testing.T) {
+	ms := func(n ...float64) []time.Duration {
+		var d []time.Duration
+		for _, v := range n {
+			d = append(d, time.Duration(v*float64(time.Millisecond)))
+		}
+		return d
+	}
+	for _, tc := range []struct {
+		name string
+		sent int
+		rtts []time.Duration
+		want PingStats
+	}{
+		{
+			name: "all answered",
+			sent: 4,
+			rtts: ms(2, 4, 4, 6),
+			want: PingStats{Sent: 4, Received: 4, MinMs: 2, AvgMs: 4, MaxMs: 6, StddevMs: math.Sqrt2},
+		},
+		{
+			name: "one answered",
+			sent: 1,
+			rtts: ms(3),
+			want: PingStats{Sent: 1, Received: 1, MinMs: 3, AvgMs: 3, MaxMs: 3},
+		},
+		{
+			name: "some lost",
+			sent: 4,
+			rtts: ms(1, 3),
+			want: PingStats{Sent: 4, Received: 2, LossPct: 50, MinMs: 1, AvgMs: 2, MaxMs: 3, StddevMs: 1},
+		},
+		{
+			name: "unsorted",
+			sent: 3,
+			rtts: ms(9, 1, 5),
+			want: PingStats{Sent: 3, Received: 3, MinMs: 1, AvgMs: 5, MaxMs: 9, StddevMs: math.Sqrt(32.0 / 3)},
+		},
+		{
+			name: "all lost",
+			sent: 3,
+			want: PingStats{Sent: 3, LossPct: 100},
+		},
+		{
+			name: "none sent",
+			want: PingStats{},
+		},
+	} {
+		got := newPingStats(tc.sent, tc.rtts)
+		if got.Sent != tc.want.Sent || got.Received != tc.want.Received || !near(got.LossPct, tc.want.LossPct) ||
+			!near(got.MinMs, tc.want.MinMs) || !near(got.AvgMs, tc.want.AvgMs) ||
+			!near(got.MaxMs, tc.want.MaxMs) || !near(got.StddevMs, tc.want.StddevMs) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// privateNetworkOpts loads the swarm key (/key/swarm/psk/1.0.0/ format) of
+// the private_network section. With mismatch set every key byte is inverted,
+// so the peer speaks the right framing with the wrong key and every handshake
+// with a correctly keyed peer fails.
+func privateNetworkOpts(cfg *PeerConfig, transport string) ([]libp2p.Option, error) {
+	if cfg == nil || cfg.PrivateNetwork.PSKFile == "" {
+		return nil, nil
+	}
+	transports, err := parseTransports(transport)
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(transports, "quic") {
+		return nil, fmt.Errorf("private networks are not supported over quic")
+	}
+
+	f, err := os.Open(cfg.PrivateNetwork.PSKFile)
+	if err != nil {
+		return nil, fmt.Errorf("psk file: %w", err)
+	}
+	defer f.Close()
+	psk, err := pnet.DecodeV1PSK(f)
+	if err != nil {
+		return nil, fmt.Errorf("psk file %s: %w", cfg.PrivateNetwork.PSKFile, err)
+	}
+	if cfg.PrivateNetwork.Mismatch {
+		for i := range psk {
+			psk[i] ^= 0xff
+		}
+	}
+
+	fp := pskFingerprint(psk)
+	emit(Event{Type: "psk", Message: fp}, "PSKFingerprint: %s", fp)
+	return []libp2p.Option{libp2p.PrivateNetwork(psk)}, nil
+}
+
+// pskFingerprint is the first 8 bytes of the key's SHA-256, enough to tell
+// two keys apart in logs without printing the key.
+func pskFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceManagerConfig is the resource_manager section of PeerConfig. Unset
+// limits keep go-libp2p's defaults, or no limit at all with Infinite.
+type ResourceManagerConfig struct {
+	Infinite  bool                   `yaml:"infinite"`
+	System    ScopeLimits            `yaml:"system"`
+	Transient ScopeLimits            `yaml:"transient"`
+	Peer      ScopeLimits            `yaml:"peer"`      // applied to every peer
+	Protocol  ScopeLimits            `yaml:"protocol"`  // applied to every protocol
+	Protocols map[string]ScopeLimits `yaml:"protocols"` // per protocol ID
+}
+
+// ScopeLimits are the limits of one resource scope.
+type ScopeLimits struct {
+	Streams         limitValue `yaml:"streams"`
+	StreamsInbound  limitValue `yaml:"streams_inbound"`
+	StreamsOutbound limitValue `yaml:"streams_outbound"`
+	Conns           limitValue `yaml:"conns"`
+	ConnsInbound    limitValue `yaml:"conns_inbound"`
+	ConnsOutbound   limitValue `yaml:"conns_outbound"`
+	FD              limitValue `yaml:"fd"`
+	Memory          limitValue `yaml:"memory"` // bytes
+}
+
+// limitValue is a limit as written in YAML: a number, "unlimited" (or
+// "infinite") or "blocked". 0 also blocks. Left out, the default applies.
+type limitValue int64
+
+func (l *limitValue) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Value {
+	case "unlimited", "infinite":
+		*l = limitValue(rcmgr.Unlimited)
+		return nil
+	case "blocked":
+		*l = limitValue(rcmgr.BlockAllLimit)
+		return nil
+	}
+	n, err := strconv.ParseInt(value.Value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("line %d: invalid limit %q (number, unlimited or blocked)", value.Line, value.Value)
+	}
+	if n == 0 {
+		// rcmgr reads 0 as "use the default"; in the config it means none.
+		*l = limitValue(rcmgr.BlockAllLimit)
+		return nil
+	}
+	*l = limitValue(n)
+	return nil
+}
+
+func (s ScopeLimits) resourceLimits() rcmgr.ResourceLimits {
+	return rcmgr.ResourceLimits{
+		Streams:         rcmgr.LimitVal(s.Streams),
+		StreamsInbound:  rcmgr.LimitVal(s.StreamsInbound),
+		StreamsOutbound: rcmgr.LimitVal(s.StreamsOutbound),
+		Conns:           rcmgr.LimitVal(s.Conns),
+		ConnsInbound:    rcmgr.LimitVal(s.ConnsInbound),
+		ConnsOutbound:   rcmgr.LimitVal(s.ConnsOutbound),
+		FD:              rcmgr.LimitVal(s.FD),
+		Memory:          rcmgr.LimitVal64(s.Memory),
+	}
+}
+
+// resourceManagerOpts picks the host's resource manager. Without a
+// resource_manager section TCP-style transports keep go-libp2p's default and
+// UDX keeps the null resource manager it has always used.
+func resourceManagerOpts(cfg *PeerConfig, transport string) ([]libp2p.Option, error) {
+	if cfg == nil || cfg.ResourceManager == nil {
+		transports, err := parseTransports(transport)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(transports, "udx") {
+			return []libp2p.Option{libp2p.ResourceManager(&network.NullResourceManager{})}, nil
+		}
+		return nil, nil
+	}
+	rm, err := newResourceManager(cfg.ResourceManager)
+	if err != nil {
+		return nil, fmt.Errorf("resource manager: %w", err)
+	}
+	return []libp2p.Option{libp2p.ResourceManager(rm)}, nil
+}
+
+func newResourceManager(c *ResourceManagerConfig) (network.ResourceManager, error) {
+	base := rcmgr.InfiniteLimits
+	if !c.Infinite {
+		defaults := rcmgr.DefaultLimits
+		libp2p.SetDefaultServiceLimits(&defaults)
+		base = defaults.AutoScale()
+	}
+
+	partial := rcmgr.PartialLimitConfig{
+		System:          c.System.resourceLimits(),
+		Transient:       c.Transient.resourceLimits(),
+		PeerDefault:     c.Peer.resourceLimits(),
+		ProtocolDefault: c.Protocol.resourceLimits(),
+	}
+	if len(c.Protocols) > 0 {
+		partial.Protocol = make(map[protocol.ID]rcmgr.ResourceLimits, len(c.Protocols))
+		for id, l := range c.Protocols {
+			partial.Protocol[protocol.ID(id)] = l.resourceLimits()
+		}
+	}
+
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(partial.Build(base)),
+		rcmgr.WithMetrics(blockReporter{}))
+}
+
+// blockReporter reports every resource request the resource manager refuses.
+// Allowed requests are not reported.
+type blockReporter struct{}
+
+var _ rcmgr.MetricsReporter = blockReporter{}
+
+func reportBlocked(ev Event, what string) {
+	ev.Type = "resource_blocked"
+	ev.Message = what
+	emit(ev, "ResourceBlocked: %s", what)
+}
+
+func (blockReporter) BlockConn(dir network.Direction, usefd bool) {
+	reportBlocked(Event{}, fmt.Sprintf("conn %s", dir))
+}
+
+func (blockReporter) BlockStream(p peer.ID, dir network.Direction) {
+	reportBlocked(Event{Peer: p.String()}, fmt.Sprintf("stream %s peer=%s", dir, p))
+}
+
+func (blockReporter) BlockPeer(p peer.ID) {
+	reportBlocked(Event{Peer: p.String()}, fmt.Sprintf("peer %s", p))
+}
+
+func (blockReporter) BlockProtocol(proto protocol.ID) {
+	reportBlocked(Event{Protocol: string(proto)}, fmt.Sprintf("protocol %s", proto))
+}
+
+func (blockReporter) BlockProtocolPeer(proto protocol.ID, p peer.ID) {
+	reportBlocked(Event{Peer: p.String(), Protocol: string(proto)}, fmt.Sprintf("protocol %s peer=%s", proto, p))
+}
+
+func (blockReporter) BlockService(svc string) {
+	reportBlocked(Event{}, fmt.Sprintf("service %s", svc))
+}
+
+func (blockReporter) BlockServicePeer(svc string, p peer.ID) {
+	reportBlocked(Event{Peer: p.String()}, fmt.Sprintf("service %s peer=%s", svc, p))
+}
+
+func (blockReporter) BlockMemory(size int) {
+	reportBlocked(Event{Bytes: size}, fmt.Sprintf("memory %d bytes", size))
+}
+
+func (blockReporter) AllowConn(network.Direction, bool)      {}
+func (blockReporter) AllowStream(peer.ID, network.Direction) {}
+func (blockReporter) AllowPeer(peer.ID)                      {}
+func (blockReporter) AllowProtocol(protocol.ID)              {}
+func (blockReporter) AllowService(string)                    {}
+func (blockReporter) AllowMemory(int)                        {}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLimitValue(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    limitValue
+		wantErr bool
+	}{
+		{in: "128", want: 128},
+		{in: "1", want: 1},
+		{in: "0", want: limitValue(rcmgr.BlockAllLimit)},
+		{in: "blocked", want: limitValue(rcmgr.BlockAllLimit)},
+		{in: "unlimited", want: limitValue(rcmgr.Unlimited)},
+		{in: "infinite", want: limitValue(rcmgr.Unlimited)},
+		{in: "-1", wantErr: true},
+		{in: "lots", wantErr: true},
+		{in: "1.5", wantErr: true},
+	} {
+		var got struct {
+			Streams limitValue `yaml:"streams"`
+		}
+		err := yaml.Unmarshal([]byte("streams: "+tc.in), &got)
+		switch {
+		case tc.wantErr && err == nil:
+			t.Errorf("%q: got %d, want an error", tc.in, got.Streams)
+		case !tc.wantErr && (err != nil || got.Streams != tc.want):
+			t.Errorf("%q: got %d, %v, want %d", tc.in, got.Streams, err, tc.want)
+		}
+	}
+}
+
+func TestScopeLimitsDefaults(t *testing.T) {
+	var cfg ResourceManagerConfig
+	if err := yaml.Unmarshal([]byte("peer:\n  streams_inbound: 2\n  memory: unlimited\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	got := cfg.Peer.resourceLimits()
+	if got.StreamsInbound != 2 || got.Memory != rcmgr.Unlimited64 {
+		t.Errorf("set limits: got %+v", got)
+	}
+	// Left-out limits stay at 0, which rcmgr reads as "use the default".
+	if got.Streams != rcmgr.DefaultLimit || got.Conns != rcmgr.DefaultLimit {
+		t.Errorf("unset limits: got %+v", got)
+	}
+}
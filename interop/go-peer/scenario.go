@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"gopkg.in/yaml.v3"
+)
+
+const defaultStepTimeout = 30 * time.Second
+
+// Scenario is a YAML file describing an ordered list of steps run against a
+// single host. Environment variables in the file are expanded before parsing,
+// and steps without a target fall back to --target.
+type Scenario struct {
+	Name      string         `yaml:"name"`
+	Transport string         `yaml:"transport"` // overrides --transport
+	Port      int            `yaml:"port"`      // overrides --port
+	Config    *PeerConfig    `yaml:"config"`    // merged over --config and flags
+	Steps     []ScenarioStep `yaml:"steps"`
+}
+
+// ScenarioStep is a single action. Which fields apply depends on Action:
+//
+//	listen            protocols (echo handlers to register)
+//	connect           target
+//	wait-identify     peer (defaults to the current peer, or the first one identified)
+//	open-stream       protocol
+//	send              data or bytes (random payload of that size)
+//	expect-echo       reads back everything sent since the last expect-echo
+//	close-stream
+//	publish           topic, data
+//	assert-peerstore  peer, protocols, agent, min_addrs
+//	sleep             duration
+//	disconnect        peer
+type ScenarioStep struct {
+	Action    string       `yaml:"action"`
+	Target    string       `yaml:"target"`
+	Peer      string       `yaml:"peer"`
+	Protocol  string       `yaml:"protocol"`
+	Protocols []string     `yaml:"protocols"`
+	Data      string       `yaml:"data"`
+	Bytes     int          `yaml:"bytes"`
+	Topic     string       `yaml:"topic"`
+	Agent     string       `yaml:"agent"`
+	MinAddrs  int          `yaml:"min_addrs"`
+	Duration  yamlDuration `yaml:"duration"`
+	Timeout   yamlDuration `yaml:"timeout"`
+}
+
+// yamlDuration accepts Go duration strings ("500ms", "2s") or plain integers
+// meaning seconds, matching the integer-seconds convention of PeerConfig.
+type yamlDuration time.Duration
+
+func (d *yamlDuration) UnmarshalYAML(value *yaml.Node) error {
+	if secs, err := strconv.Atoi(value.Value); err == nil {
+		*d = yamlDuration(time.Duration(secs) * time.Second)
+		return nil
+	}
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q", value.Line, value.Value)
+	}
+	*d = yamlDuration(parsed)
+	return nil
+}
+
+// StepResult is one entry of the scenario report.
+type StepResult struct {
+	Index      int     `json:"index"`
+	Action     string  `json:"action"`
+	Status     string  `json:"status"` // passed, failed or skipped
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// ScenarioReport is printed to stdout as a single JSON line when the
+// scenario ends, whatever --output says.
+type ScenarioReport struct {
+	Type       string       `json:"type"`
+	Scenario   string       `json:"scenario"`
+	Peer       string       `json:"peer"`
+	Passed     bool         `json:"passed"`
+	DurationMs float64      `json:"duration_ms"`
+	Steps      []StepResult `json:"steps"`
+}
+
+// loadScenario parses a scenario file. Its config section is decoded over a
+// copy of base, so it only overrides the settings it names.
+func loadScenario(path string, base *PeerConfig) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+	merged := *base
+	sc := Scenario{Config: &merged}
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &sc); err != nil {
+		return nil, fmt.Errorf("parse scenario: %w", err)
+	}
+	if len(sc.Steps) == 0 {
+		return nil, errors.New("scenario has no steps")
+	}
+	return &sc, nil
+}
+
+// scenarioRunner holds the state steps share: the host, the peer and stream
+// the last steps worked with, and bytes still waiting to be echoed back.
+type scenarioRunner struct {
+	h             host.Host
+	defaultTarget string
+
+	current peer.ID
+	stream  network.Stream
+	pending []byte
+	echo    chan echoRead // the echo of pending, read while it is sent
+
+	ps     *pubsub.PubSub
+	topics map[string]*pubsub.Topic
+
+	identifiedMu sync.Mutex
+	identified   map[peer.ID]bool
+	identifiedCh chan struct{} // closed and replaced whenever a peer is identified
+}
+
+// echoRead is what came back for the bytes sent since the last expect-echo.
+type echoRead struct {
+	data []byte
+	err  error
+}
+
+// scenario mode: run the steps of a YAML scenario and report pass/fail
+func runScenario(path string, port int, transport, defaultTarget string, cfg *PeerConfig) {
+	sc, err := loadScenario(path, cfg)
+	if err != nil {
+		fatal("config", "Error loading scenario: %v", err)
+	}
+	if sc.Transport != "" {
+		transport = sc.Transport
+	}
+	if sc.Port != 0 {
+		port = sc.Port
+	}
+	cfg = sc.Config
+	startYamuxStats(cfg)
+	if sc.Name == "" {
+		sc.Name = path
+	}
+
+	h, err := createHost(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+
+	r, err := newScenarioRunner(h, defaultTarget)
+	if err != nil {
+		fatal("host", "Event bus error: %v", err)
+	}
+
+	report := ScenarioReport{Type: "scenario_report", Scenario: sc.Name, Peer: h.ID().String(), Passed: true}
+	start := time.Now()
+	for i, step := range sc.Steps {
+		if !report.Passed {
+			report.Steps = append(report.Steps, StepResult{Index: i, Action: step.Action, Status: "skipped"})
+			continue
+		}
+
+		timeout := time.Duration(step.Timeout)
+		if timeout <= 0 {
+			timeout = defaultStepTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		stepStart := time.Now()
+		err := r.run(ctx, step)
+		cancel()
+
+		res := StepResult{Index: i, Action: step.Action, Status: "passed", DurationMs: msec(time.Since(stepStart))}
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+			report.Passed = false
+		}
+		report.Steps = append(report.Steps, res)
+		emit(Event{Type: "step", Message: step.Action, Error: res.Error}, "Step %d %s: %s", i, step.Action, res.Status)
+	}
+	report.DurationMs = msec(time.Since(start))
+
+	writeJSON(report)
+	h.Close()
+	if !report.Passed {
+		os.Exit(1)
+	}
+}
+
+// newScenarioRunner returns a runner for steps on h that starts recording
+// identified peers right away.
+func newScenarioRunner(h host.Host, defaultTarget string) (*scenarioRunner, error) {
+	r := &scenarioRunner{
+		h:             h,
+		defaultTarget: defaultTarget,
+		topics:        make(map[string]*pubsub.Topic),
+		identified:    make(map[peer.ID]bool),
+		identifiedCh:  make(chan struct{}),
+	}
+	if err := r.watchIdentify(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// watchIdentify records every peer whose identify exchange completed.
+func (r *scenarioRunner) watchIdentify() error {
+	sub, err := r.h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	go func() {
+		for e := range sub.Out() {
+			evt := e.(event.EvtPeerIdentificationCompleted)
+			r.identifiedMu.Lock()
+			r.identified[evt.Peer] = true
+			close(r.identifiedCh)
+			r.identifiedCh = make(chan struct{})
+			r.identifiedMu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (r *scenarioRunner) run(ctx context.Context, step ScenarioStep) error {
+	switch step.Action {
+	case "listen":
+		for _, p := range step.Protocols {
+			r.h.SetStreamHandler(protocol.ID(p), echoHandler(false))
+		}
+		printHostInfo(r.h)
+		return nil
+
+	case "connect":
+		target := step.Target
+		if target == "" {
+			target = r.defaultTarget
+		}
+		if target == "" {
+			return errors.New("connect: target required (step target or --target)")
+		}
+		info, err := parseTarget(target)
+		if err != nil {
+			return err
+		}
+		if err := r.h.Connect(ctx, *info); err != nil {
+			return err
+		}
+		r.current = info.ID
+		return nil
+
+	case "wait-identify":
+		return r.waitIdentify(ctx, step.Peer)
+
+	case "open-stream":
+		if step.Protocol == "" {
+			return errors.New("open-stream: protocol required")
+		}
+		pid, err := r.peer(step.Peer)
+		if err != nil {
+			return err
+		}
+		s, err := r.h.NewStream(network.WithAllowLimitedConn(ctx, "scenario"), pid, protocol.ID(step.Protocol))
+		if err != nil {
+			return err
+		}
+		if r.stream != nil {
+			r.stream.Close()
+		}
+		r.stream, r.pending, r.echo = s, nil, nil
+		return nil
+
+	case "send":
+		if r.stream == nil {
+			return errors.New("send: no open stream")
+		}
+		payload := []byte(step.Data)
+		if step.Bytes > 0 {
+			payload = make([]byte, step.Bytes)
+			rand.Read(payload)
+		}
+		// Read the echo while writing: once a payload outgrows the stream
+		// windows the peer stops reading until its echo is read.
+		r.pending = append(r.pending, payload...)
+		r.echo = readEcho(r.stream, len(payload), r.echo)
+		r.stream.SetWriteDeadline(stepDeadline(ctx))
+		_, err := r.stream.Write(payload)
+		return err
+
+	case "expect-echo":
+		if r.stream == nil {
+			return errors.New("expect-echo: no open stream")
+		}
+		if r.echo == nil {
+			return nil
+		}
+		r.stream.SetReadDeadline(stepDeadline(ctx))
+		got := <-r.echo
+		r.echo = nil
+		r.stream.SetReadDeadline(time.Time{})
+		if got.err != nil {
+			return fmt.Errorf("read %d bytes: %w", len(r.pending), got.err)
+		}
+		if !bytes.Equal(got.data, r.pending) {
+			return fmt.Errorf("echo mismatch over %d bytes", len(r.pending))
+		}
+		r.pending = nil
+		return nil
+
+	case "close-stream":
+		if r.stream == nil {
+			return errors.New("close-stream: no open stream")
+		}
+		err := r.stream.Close()
+		r.stream, r.pending, r.echo = nil, nil, nil
+		return err
+
+	case "publish":
+		if step.Topic == "" {
+			return errors.New("publish: topic required")
+		}
+		t, err := r.topic(step.Topic)
+		if err != nil {
+			return err
+		}
+		return t.Publish(ctx, []byte(step.Data))
+
+	case "assert-peerstore":
+		return r.assertPeerstore(step)
+
+	case "sleep":
+		select {
+		case <-time.After(time.Duration(step.Duration)):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+	case "disconnect":
+		pid, err := r.peer(step.Peer)
+		if err != nil {
+			return err
+		}
+		return r.h.Network().ClosePeer(pid)
+	}
+	return fmt.Errorf("unknown action %q", step.Action)
+}
+
+// peer resolves an explicit peer ID or falls back to the current peer.
+func (r *scenarioRunner) peer(id string) (peer.ID, error) {
+	if id != "" {
+		return peer.Decode(id)
+	}
+	if r.current == "" {
+		return "", errors.New("no current peer: connect or wait-identify first")
+	}
+	return r.current, nil
+}
+
+// waitIdentify blocks until the given (or current) peer is identified. With
+// no peer at all it waits for any peer, which covers inbound connections.
+func (r *scenarioRunner) waitIdentify(ctx context.Context, id string) error {
+	var want peer.ID
+	if id != "" || r.current != "" {
+		pid, err := r.peer(id)
+		if err != nil {
+			return err
+		}
+		want = pid
+	}
+	for {
+		r.identifiedMu.Lock()
+		ch := r.identifiedCh
+		if want != "" && r.identified[want] {
+			r.identifiedMu.Unlock()
+			r.current = want
+			return nil
+		}
+		if want == "" {
+			for p := range r.identified {
+				r.identifiedMu.Unlock()
+				r.current = p
+				return nil
+			}
+		}
+		r.identifiedMu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return fmt.Errorf("identify not completed: %w", ctx.Err())
+		}
+	}
+}
+
+func (r *scenarioRunner) assertPeerstore(step ScenarioStep) error {
+	pid, err := r.peer(step.Peer)
+	if err != nil {
+		return err
+	}
+	ps := r.h.Peerstore()
+
+	protos, err := ps.GetProtocols(pid)
+	if err != nil {
+		return err
+	}
+	for _, want := range step.Protocols {
+		if !slices.Contains(protos, protocol.ID(want)) {
+			return fmt.Errorf("peer %s does not list protocol %s", pid, want)
+		}
+	}
+	if step.Agent != "" {
+		agent, err := ps.Get(pid, "AgentVersion")
+		if err != nil {
+			return fmt.Errorf("agent version: %w", err)
+		}
+		if agent != step.Agent {
+			return fmt.Errorf("agent version %q, want %q", agent, step.Agent)
+		}
+	}
+	if n := len(ps.Addrs(pid)); n < step.MinAddrs {
+		return fmt.Errorf("peerstore has %d addrs for %s, want at least %d", n, pid, step.MinAddrs)
+	}
+	return nil
+}
+
+// topic joins a pubsub topic, starting GossipSub on first use.
+func (r *scenarioRunner) topic(name string) (*pubsub.Topic, error) {
+	if t, ok := r.topics[name]; ok {
+		return t, nil
+	}
+	if r.ps == nil {
+		ps, err := pubsub.NewGossipSub(context.Background(), r.h)
+		if err != nil {
+			return nil, err
+		}
+		r.ps = ps
+	}
+	t, err := r.ps.Join(name)
+	if err != nil {
+		return nil, err
+	}
+	r.topics[name] = t
+	return t, nil
+}
+
+// readEcho reads the n byte echo of a send in the background, after the
+// echo of the sends before it (prev, if any), and delivers all of it.
+func readEcho(s network.Stream, n int, prev chan echoRead) chan echoRead {
+	ch := make(chan echoRead, 1)
+	go func() {
+		var got echoRead
+		if prev != nil {
+			if got = <-prev; got.err != nil {
+				ch <- got
+				return
+			}
+		}
+		buf := make([]byte, n)
+		_, got.err = io.ReadFull(s, buf)
+		got.data = append(got.data, buf...)
+		ch <- got
+	}()
+	return ch
+}
+
+// stepDeadline returns the step's deadline, or the zero time for none.
+func stepDeadline(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	return deadline
+}
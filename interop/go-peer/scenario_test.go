@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+func TestScenarioLargeEcho(t *testing.T) {
+	l, err := createHost(0, "tcp", &PeerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(false))
+
+	h, err := createHost(0, "tcp", &PeerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	r, err := newScenarioRunner(h, l.Addrs()[0].String()+"/p2p/"+l.ID().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each payload is far past the 256 KiB yamux windows, so the echo has
+	// to be drained while the payload is still being written.
+	for i, step := range []ScenarioStep{
+		{Action: "connect"},
+		{Action: "open-stream", Protocol: echoProtocol},
+		{Action: "send", Bytes: 4 << 20},
+		{Action: "send", Data: "tail"},
+		{Action: "expect-echo"},
+		{Action: "send", Bytes: 1 << 20},
+		{Action: "expect-echo"},
+		{Action: "close-stream"},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := r.run(ctx, step)
+		cancel()
+		if err != nil {
+			t.Fatalf("step %d %s: %v", i, step.Action, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+)
+
+// knownSecurity lists the values --security accepts, alone or
+// comma-separated in order of preference.
+var knownSecurity = []string{"noise", "tls"}
+
+// parseSecurity splits a --security value and rejects unknown names. An empty
+// value means Noise only.
+func parseSecurity(s string) ([]string, error) {
+	if s == "" {
+		return []string{"noise"}, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(knownSecurity, name) {
+			return nil, fmt.Errorf("unknown security transport %q (known: %s)", name, strings.Join(knownSecurity, ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// securityOpts offers the security transports of cfg.Security in its order;
+// the first one is the one this peer proposes when it dials. QUIC has TLS
+// built in and ignores these.
+//
+// Both Noise and TLS advertise the host's muxers inside the handshake (Noise
+// extensions, TLS ALPN) unless early muxer negotiation is turned off, in
+// which case they are handed no muxers and multistream picks one afterwards.
+func securityOpts(cfg *PeerConfig) ([]libp2p.Option, error) {
+	var security string
+	early := true
+	if cfg != nil {
+		security = cfg.Security
+		if cfg.EarlyMuxer != nil {
+			early = *cfg.EarlyMuxer
+		}
+	}
+	names, err := parseSecurity(security)
+	if err != nil {
+		return nil, err
+	}
+	var opts []libp2p.Option
+	for _, name := range names {
+		switch name {
+		case "noise":
+			if early {
+				opts = append(opts, libp2p.Security(noise.ID, noise.New))
+			} else {
+				opts = append(opts, libp2p.Security(noise.ID, func(id protocol.ID, priv crypto.PrivKey) (*noise.Transport, error) {
+					return noise.New(id, priv, nil)
+				}))
+			}
+		case "tls":
+			if early {
+				opts = append(opts, libp2p.Security(libp2ptls.ID, libp2ptls.New))
+			} else {
+				opts = append(opts, libp2p.Security(libp2ptls.ID, func(id protocol.ID, priv crypto.PrivKey) (*libp2ptls.Transport, error) {
+					return libp2ptls.New(id, priv, nil)
+				}))
+			}
+		}
+	}
+	return opts, nil
+}
+
+// defaultMuxers is the muxer list when the config doesn't set one.
+var defaultMuxers = []string{"/yamux/1.0.0"}
+
+// muxerOpts registers cfg.Muxers in order of preference. Yamux is the only
+// muxer this peer implements, so only yamux IDs are accepted; anything else
+// would be served by yamux under another muxer's name.
+func muxerOpts(cfg *PeerConfig) ([]libp2p.Option, error) {
+	ids := defaultMuxers
+	if cfg != nil && len(cfg.Muxers) > 0 {
+		ids = cfg.Muxers
+	}
+	mux, err := yamuxMuxer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := make([]libp2p.Option, 0, len(ids))
+	for _, id := range ids {
+		if !strings.HasPrefix(id, "/yamux/") {
+			return nil, fmt.Errorf("unsupported muxer %q: only /yamux/ IDs are implemented", id)
+		}
+		opts = append(opts, libp2p.Muxer(id, mux))
+	}
+	return opts, nil
+}
+
+// startConnReport prints the negotiated security and muxer of every new
+// connection, and whether the muxer was agreed inside the security handshake,
+// when --security, --early-muxer or --muxers is set.
+func startConnReport(h host.Host, cfg *PeerConfig) {
+	if cfg == nil || (cfg.Security == "" && cfg.EarlyMuxer == nil && len(cfg.Muxers) == 0) {
+		return
+	}
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			st := c.ConnState()
+			early := st.UsedEarlyMuxerNegotiation
+			ev := Event{
+				Type:       "conn_security",
+				Peer:       c.RemotePeer().String(),
+				Addrs:      []string{c.RemoteMultiaddr().String()},
+				Protocol:   string(st.Security),
+				Message:    string(st.StreamMultiplexer),
+				EarlyMuxer: &early,
+			}
+			emit(ev, "ConnSecurity: %s muxer=%s early=%t peer=%s", st.Security, st.StreamMultiplexer, early, c.RemotePeer())
+		},
+	})
+}
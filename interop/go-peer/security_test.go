@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+)
+
+func TestParseSecurity(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string // nil for an error
+	}{
+		{"", []string{"noise"}},
+		{"noise", []string{"noise"}},
+		{"tls", []string{"tls"}},
+		{"tls,noise", []string{"tls", "noise"}},
+		{" noise , tls ", []string{"noise", "tls"}},
+		{"noise,noise", []string{"noise"}},
+		{"secio", nil},
+		{"noise,", nil},
+		{"Noise", nil},
+	} {
+		got, err := parseSecurity(tc.in)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestSecurityNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		dialer, listener string
+		want             protocol.ID
+	}{
+		{"", "", noise.ID},
+		{"tls", "noise,tls", libp2ptls.ID},
+		{"tls,noise", "noise,tls", libp2ptls.ID}, // the dialer's preference wins
+		{"noise,tls", "tls", libp2ptls.ID},
+	} {
+		l, err := createHost(0, "tcp", &PeerConfig{Security: tc.listener})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := createHost(0, "tcp", &PeerConfig{Security: tc.dialer})
+		if err != nil {
+			l.Close()
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = d.Connect(ctx, peer.AddrInfo{ID: l.ID(), Addrs: l.Addrs()})
+		cancel()
+		if err != nil {
+			t.Errorf("%q to %q: %v", tc.dialer, tc.listener, err)
+		} else if conns := d.Network().ConnsToPeer(l.ID()); len(conns) == 0 || conns[0].ConnState().Security != tc.want {
+			t.Errorf("%q to %q: got %v, want %s", tc.dialer, tc.listener, conns, tc.want)
+		}
+		d.Close()
+		l.Close()
+	}
+}
+
+func TestMuxerNegotiation(t *testing.T) {
+	for _, tc := range []struct {
+		dialer, listener []string
+		want             protocol.ID // empty if the host must fail to start
+	}{
+		{nil, nil, "/yamux/1.0.0"},
+		{[]string{"/yamux/2.0.0", "/yamux/1.0.0"}, nil, "/yamux/1.0.0"},
+		{[]string{"/yamux/2.0.0"}, []string{"/yamux/1.0.0", "/yamux/2.0.0"}, "/yamux/2.0.0"},
+		{[]string{"/mplex/6.7.0"}, nil, ""},
+		{[]string{"/yamux/1.0.0", "/fancy/1.0.0"}, nil, ""},
+	} {
+		l, err := createHost(0, "tcp", &PeerConfig{Muxers: tc.listener})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := createHost(0, "tcp", &PeerConfig{Muxers: tc.dialer})
+		if tc.want == "" {
+			if err == nil {
+				d.Close()
+				t.Errorf("%q: host started, want an error", tc.dialer)
+			}
+			l.Close()
+			continue
+		}
+		if err != nil {
+			l.Close()
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = d.Connect(ctx, peer.AddrInfo{ID: l.ID(), Addrs: l.Addrs()})
+		cancel()
+		if err != nil {
+			t.Errorf("%q to %q: %v", tc.dialer, tc.listener, err)
+		} else if conns := d.Network().ConnsToPeer(l.ID()); len(conns) == 0 || conns[0].ConnState().StreamMultiplexer != tc.want {
+			t.Errorf("%q to %q: got %v, want %s", tc.dialer, tc.listener, conns, tc.want)
+		}
+		d.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// knownServices lists what --services can mount, in the order they start.
+var knownServices = []string{"echo", "ping", "relay", "dht", "pubsub", "autonat", "holepunch", "perf"}
+
+// parseServices splits a --services value and rejects unknown names.
+func parseServices(s string) ([]string, error) {
+	var services []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(knownServices, name) {
+			return nil, fmt.Errorf("unknown service %q (known: %s)", name, strings.Join(knownServices, ", "))
+		}
+		if !slices.Contains(services, name) {
+			services = append(services, name)
+		}
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no services given")
+	}
+	return services, nil
+}
+
+// serviceHostOptions returns the host options the services need at
+// construction time. Ping is on by default in libp2p, so it is switched off
+// unless asked for.
+func serviceHostOptions(services []string) []libp2p.Option {
+	var opts []libp2p.Option
+	if !slices.Contains(services, "ping") {
+		opts = append(opts, libp2p.Ping(false))
+	}
+	if slices.Contains(services, "autonat") {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+	if slices.Contains(services, "holepunch") {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+	return opts
+}
+
+// newServerDHT starts a Kademlia DHT in server mode that accepts loopback and
+// private addresses, as every local test topology needs.
+func newServerDHT(ctx context.Context, h host.Host) (*dht.IpfsDHT, error) {
+	return dht.New(ctx, h,
+		dht.Mode(dht.ModeServer),
+		dht.AddressFilter(func(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+			return addrs // Accept all addresses including loopback
+		}),
+	)
+}
+
+// stdinCommand runs a stdin command with the words after its name. Its
+// error is printed to stderr, or carried by the reply in daemon mode.
+type stdinCommand func(args []string) error
+
+// stdinCommands holds the commands watchStdinQuit runs besides quit/exit,
+// keyed by the first word of the line.
+var (
+	stdinCommandsMu sync.Mutex
+	stdinCommands   = map[string]stdinCommand{}
+)
+
+// registerStdinCommand makes watchStdinQuit run fn for lines starting with
+// name; the remaining words are passed as args.
+func registerStdinCommand(name string, fn stdinCommand) {
+	stdinCommandsMu.Lock()
+	defer stdinCommandsMu.Unlock()
+	stdinCommands[name] = fn
+}
+
+// lookupStdinCommand returns the command registered as name, or nil.
+func lookupStdinCommand(name string) stdinCommand {
+	stdinCommandsMu.Lock()
+	defer stdinCommandsMu.Unlock()
+	return stdinCommands[name]
+}
+
+// watchStdinQuit exits the process when "quit" or "exit" is read from stdin,
+// running onQuit first if it is set. Other lines run the matching registered
+// command.
+func watchStdinQuit(onQuit func()) {
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+			if fields[0] == "quit" || fields[0] == "exit" {
+				if onQuit != nil {
+					onQuit()
+				}
+				flushYamuxTraces()
+				os.Exit(0)
+			}
+			fn := lookupStdinCommand(fields[0])
+			if fn == nil {
+				fmt.Fprintf(os.Stderr, "unknown command: %s\n", fields[0])
+				continue
+			}
+			if err := fn(fields[1:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}()
+}
+
+// services mode: mount any combination of services on a single host
+func runServices(port int, transport string, services []string, topicName string, cfg *PeerConfig) {
+	opts := serviceHostOptions(services)
+	var h host.Host
+	var err error
+	// Hole punching coordinates over relayed connections, so it needs the relay client too.
+	if slices.Contains(services, "relay") || slices.Contains(services, "holepunch") {
+		h, err = createHostWithRelay(port, transport, cfg, opts...)
+	} else {
+		h, err = createHost(port, transport, cfg, opts...)
+	}
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	ctx := context.Background()
+	for _, name := range services {
+		switch name {
+		case "echo":
+			h.SetStreamHandler(protocol.ID(echoProtocol), echoHandler(false))
+		case "perf":
+			h.SetStreamHandler(protocol.ID(perfProtocol), perfHandler)
+		case "relay":
+			if _, err := relayv2.New(h); err != nil {
+				fatal("relay", "Relay service error: %v", err)
+			}
+		case "dht":
+			kadDHT, err := newServerDHT(ctx, h)
+			if err != nil {
+				fatal("dht", "DHT error: %v", err)
+			}
+			defer kadDHT.Close()
+			if err := kadDHT.Bootstrap(ctx); err != nil {
+				fatal("dht", "DHT bootstrap error: %v", err)
+			}
+		case "pubsub":
+			if err := subscribeAndReport(ctx, h, topicName); err != nil {
+				fatal("pubsub", "%v", err)
+			}
+		}
+	}
+
+	printHostInfo(h)
+	watchStdinQuit(nil)
+	waitForShutdown()
+}
+
+// subscribeAndReport starts GossipSub, joins topicName and prints every
+// message received from other peers.
+func subscribeAndReport(ctx context.Context, h host.Host, topicName string) error {
+	ps, err := pubsub.NewGossipSub(ctx, h,
+		pubsub.WithRawTracer(&debugTracer{}),
+	)
+	if err != nil {
+		return fmt.Errorf("GossipSub error: %w", err)
+	}
+
+	topic, err := ps.Join(topicName)
+	if err != nil {
+		return fmt.Errorf("Join topic error: %w", err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return fmt.Errorf("Subscribe error: %w", err)
+	}
+
+	go func() {
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Sub.Next error: %v\n", err)
+				return
+			}
+			// Skip our own messages
+			if msg.ReceivedFrom == h.ID() {
+				continue
+			}
+			emit(Event{Type: "message", Peer: msg.GetFrom().String(), Topic: topicName, Message: string(msg.Data), Bytes: len(msg.Data)}, "Received: %s", string(msg.Data))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// slowReader throttles the echo streams of slow-echo-server mode. Its rate
+// and pauses can be changed on stdin while streams are running.
+type slowReader struct {
+	mu         sync.Mutex
+	rate       int       // bytes per second, 0 for unthrottled
+	pauseUntil time.Time // no reads before this
+}
+
+// slowReadChunk caps each read, so throttled reads stay smooth and a pause
+// takes effect within one chunk.
+const slowReadChunk = 4 * 1024
+
+// slow-echo-server mode: echo with reads throttled to rate bytes per second,
+// pausing for pause once pauseAfter bytes of a stream have been read
+func runSlowEchoServer(port int, transport string, rate int, pause time.Duration, pauseAfter int, cfg *PeerConfig) {
+	h, err := createHost(port, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	r := &slowReader{rate: rate}
+	h.SetStreamHandler(protocol.ID(echoProtocol), r.echoHandler(pause, pauseAfter))
+	registerStdinCommand("pause", r.pauseCommand)
+	registerStdinCommand("rate", r.rateCommand)
+
+	printHostInfo(h)
+	watchStdinQuit(nil)
+	waitForShutdown()
+}
+
+// pauseCommand handles "pause <duration>": every stream stops reading now.
+func (r *slowReader) pauseCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: pause <duration>")
+	}
+	d, err := time.ParseDuration(args[0])
+	if err != nil {
+		return fmt.Errorf("pause: %w", err)
+	}
+	r.pauseFor(d)
+	emit(Event{Type: "slow_echo", Message: "paused " + d.String()}, "SlowEcho: paused all streams for %s", d)
+	return nil
+}
+
+// rateCommand handles "rate <bytes-per-second>"; 0 removes the throttle.
+func (r *slowReader) rateCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rate <bytes-per-second>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return fmt.Errorf("rate: invalid rate %q", args[0])
+	}
+	r.mu.Lock()
+	r.rate = n
+	r.mu.Unlock()
+	emit(Event{Type: "slow_echo", Message: "rate " + args[0]}, "SlowEcho: read rate %d B/s", n)
+	return nil
+}
+
+func (r *slowReader) pauseFor(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if until := time.Now().Add(d); until.After(r.pauseUntil) {
+		r.pauseUntil = until
+	}
+}
+
+// wait blocks until the reader is allowed to read again and returns the
+// current rate.
+func (r *slowReader) wait() int {
+	for {
+		r.mu.Lock()
+		until, rate := r.pauseUntil, r.rate
+		r.mu.Unlock()
+		d := time.Until(until)
+		if d <= 0 {
+			return rate
+		}
+		time.Sleep(d)
+	}
+}
+
+func (r *slowReader) echoHandler(pause time.Duration, pauseAfter int) network.StreamHandler {
+	return func(s network.Stream) {
+		defer s.Close()
+		p := s.Conn().RemotePeer().String()
+		start := time.Now()
+		buf := make([]byte, slowReadChunk)
+		total := 0
+		paused := pause == 0
+
+		for {
+			if !paused && total >= pauseAfter {
+				paused = true
+				emit(Event{Type: "slow_echo", Peer: p, Bytes: total, Message: "paused " + pause.String()},
+					"SlowEcho: pausing %s after %d bytes", pause, total)
+				time.Sleep(pause)
+				emit(Event{Type: "slow_echo", Peer: p, Bytes: total, Message: "resumed"},
+					"SlowEcho: resumed after %d bytes", total)
+			}
+			rate := r.wait()
+
+			chunk := buf
+			if !paused && pauseAfter-total < len(chunk) {
+				chunk = buf[:pauseAfter-total]
+			}
+			n, err := s.Read(chunk)
+			if n > 0 {
+				total += n
+				if _, werr := s.Write(chunk[:n]); werr != nil {
+					fmt.Fprintf(os.Stderr, "Echo write error: %v\n", werr)
+					return
+				}
+				if rate > 0 {
+					time.Sleep(time.Duration(n) * time.Second / time.Duration(rate))
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					fmt.Fprintf(os.Stderr, "Echo read error: %v\n", err)
+				}
+				elapsed := time.Since(start)
+				emit(Event{Type: "slow_echo", Peer: p, Bytes: total, Message: "done"},
+					"SlowEcho: echoed %d bytes in %s (%.0f B/s)", total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds())
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+// stressStreamTimeout bounds each stress stream from open to the last echoed
+// byte.
+const stressStreamTimeout = 30 * time.Second
+
+// StressStats summarises a stream-stress run, reported as the stress field
+// of stress_result events. Latencies run from opening a stream to reading
+// its last echoed byte, over the streams that succeeded.
+type StressStats struct {
+	Streams     int     `json:"streams"`
+	Failed      int     `json:"failed"`
+	PeakOpen    int     `json:"peak_open"`
+	BytesEchoed int64   `json:"bytes_echoed"`
+	MinMs       float64 `json:"min_ms"`
+	P50Ms       float64 `json:"p50_ms"`
+	P90Ms       float64 `json:"p90_ms"`
+	P99Ms       float64 `json:"p99_ms"`
+	MaxMs       float64 `json:"max_ms"`
+	ElapsedMs   float64 `json:"elapsed_ms"`
+}
+
+// stressRun tracks the streams of a stream-stress run.
+type stressRun struct {
+	mu        sync.Mutex
+	open      int
+	peak      int
+	echoed    int64
+	failed    int
+	latencies []time.Duration
+}
+
+func (r *stressRun) opened() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.open++
+	r.peak = max(r.peak, r.open)
+}
+
+func (r *stressRun) closed() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.open--
+}
+
+// stream-stress mode: echo random payloads on concurrent /echo/1.0.0 streams
+// over one connection and verify them by SHA-256
+func runStreamStress(targetStr, transport string, streams, concurrency, payloadSize, payloads int, cfg *PeerConfig) {
+	if targetStr == "" {
+		fatal("usage", "Error: --target required")
+	}
+	if streams < 1 || payloadSize < 1 || payloads < 1 {
+		fatal("usage", "Error: --streams, --payload-size and --payloads must be positive")
+	}
+	if concurrency < 1 || concurrency > streams {
+		concurrency = streams
+	}
+
+	h, err := createHost(0, transport, cfg)
+	if err != nil {
+		fatal("host", "Error: %v", err)
+	}
+	defer h.Close()
+
+	info, err := parseTarget(targetStr)
+	if err != nil {
+		fatal("address", "Error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := h.Connect(ctx, *info); err != nil {
+		fatal("dial", "Connection failed: %v", err)
+	}
+
+	r := &stressRun{}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range streams {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			n, latency, err := r.stream(h, info.ID, payloadSize, payloads)
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.echoed += n
+			if err != nil {
+				r.failed++
+				emit(Event{Type: "stress_failed", Peer: info.ID.String(), Bytes: int(n), Message: fmt.Sprintf("stream %d: %v", i, err)},
+					"StressFailed: stream %d after %d bytes: %v", i, n, err)
+				return
+			}
+			r.latencies = append(r.latencies, latency)
+		}()
+	}
+	wg.Wait()
+
+	slices.Sort(r.latencies)
+	st := StressStats{
+		Streams:     streams,
+		Failed:      r.failed,
+		PeakOpen:    r.peak,
+		BytesEchoed: r.echoed,
+		P50Ms:       msec(percentile(r.latencies, 0.50)),
+		P90Ms:       msec(percentile(r.latencies, 0.90)),
+		P99Ms:       msec(percentile(r.latencies, 0.99)),
+		ElapsedMs:   msec(time.Since(start)),
+	}
+	if len(r.latencies) > 0 {
+		st.MinMs, st.MaxMs = msec(r.latencies[0]), msec(r.latencies[len(r.latencies)-1])
+	}
+	emit(Event{Type: "stress_result", Peer: info.ID.String(), Protocol: echoProtocol, Bytes: int(st.BytesEchoed), Stress: &st},
+		"StreamStress: streams=%d failed=%d peak_open=%d bytes=%d elapsed=%.0fms latency min=%.2fms p50=%.2fms p90=%.2fms p99=%.2fms max=%.2fms",
+		st.Streams, st.Failed, st.PeakOpen, st.BytesEchoed, st.ElapsedMs, st.MinMs, st.P50Ms, st.P90Ms, st.P99Ms, st.MaxMs)
+	if st.Failed > 0 {
+		fatal("stream", "StreamStress: %d of %d streams failed", st.Failed, st.Streams)
+	}
+}
+
+// stream echoes payloads random payloads of size bytes on a new stream and
+// compares the SHA-256 of everything sent with that of everything read back.
+// It returns the bytes echoed back and the stream's latency.
+func (r *stressRun) stream(h host.Host, p peer.ID, size, payloads int) (int64, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stressStreamTimeout)
+	defer cancel()
+	start := time.Now()
+	s, err := h.NewStream(ctx, p, protocol.ID(echoProtocol))
+	if err != nil {
+		return 0, 0, fmt.Errorf("open: %w", err)
+	}
+	r.opened()
+	defer r.closed()
+	defer s.Close()
+	s.SetDeadline(start.Add(stressStreamTimeout))
+
+	// Write while reading: once the payloads outgrow the stream windows the
+	// echo has to be read before the rest can be written.
+	sent := sha256.New()
+	writeErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, size)
+		for range payloads {
+			rand.Read(buf)
+			sent.Write(buf)
+			if _, err := s.Write(buf); err != nil {
+				writeErr <- fmt.Errorf("write: %w", err)
+				return
+			}
+		}
+		writeErr <- s.CloseWrite()
+	}()
+
+	got := sha256.New()
+	n, err := io.Copy(got, s)
+	if err != nil {
+		s.Reset()
+		<-writeErr
+		return n, 0, fmt.Errorf("read: %w", err)
+	}
+	if err := <-writeErr; err != nil {
+		s.Reset()
+		return n, 0, err
+	}
+	latency := time.Since(start)
+	if want := int64(size) * int64(payloads); n != want {
+		return n, 0, fmt.Errorf("echoed %d of %d bytes", n, want)
+	}
+	if want, have := sent.Sum(nil), got.Sum(nil); !bytes.Equal(want, have) {
+		return n, 0, fmt.Errorf("SHA-256 mismatch: sent %x, got %x", want[:8], have[:8])
+	}
+	return n, latency, nil
+}
+
+// percentile returns the nearest-rank q-th percentile of sorted.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
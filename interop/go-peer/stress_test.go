@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	hundred := make([]time.Duration, 100)
+	for i := range hundred {
+		hundred[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for _, tc := range []struct {
+		name   string
+		sorted []time.Duration
+		q      float64
+		want   time.Duration
+	}{
+		{"empty", nil, 0.5, 0},
+		{"single p50", []time.Duration{7}, 0.5, 7},
+		{"single p99", []time.Duration{7}, 0.99, 7},
+		{"q zero is min", []time.Duration{1, 2, 3}, 0, 1},
+		{"q one is max", []time.Duration{1, 2, 3}, 1, 3},
+		{"nearest rank rounds up", []time.Duration{1, 2, 3, 4}, 0.5, 2},
+		{"nearest rank rounds up past half", []time.Duration{1, 2, 3, 4, 5}, 0.5, 3},
+		{"p90 of ten", []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 0.9, 9},
+		{"p50 of 100", hundred, 0.5, 50 * time.Millisecond},
+		{"p90 of 100", hundred, 0.9, 90 * time.Millisecond},
+		{"p99 of 100", hundred, 0.99, 99 * time.Millisecond},
+	} {
+		if got := percentile(tc.sorted, tc.q); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
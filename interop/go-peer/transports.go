@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	udxtransport "github.com/stephanfeb/go-libp2p-udx-transport"
+)
+
+// knownTransports lists the values --transport accepts, alone or
+// comma-separated.
+var knownTransports = []string{"tcp", "udx", "quic", "ws", "wss"}
+
+// parseTransports splits a --transport value and rejects unknown names.
+func parseTransports(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !slices.Contains(knownTransports, name) {
+			return nil, fmt.Errorf("unknown transport %q (known: %s)", name, strings.Join(knownTransports, ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no transport given")
+	}
+	return names, nil
+}
+
+// transportOpts returns the transport and listen address options for
+// --transport, which may name several transports to listen on at once. Every
+// transport listens on /ip4/0.0.0.0, plus /ip6/:: when ipv6 is set.
+func transportOpts(transport string, port int, ipv6 bool) ([]libp2p.Option, error) {
+	names, err := parseTransports(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	ipPrefixes := []string{"/ip4/0.0.0.0"}
+	if ipv6 {
+		ipPrefixes = append(ipPrefixes, "/ip6/::")
+	}
+	var listenAddrs []string
+	listenOn := func(suffix string) {
+		for _, prefix := range ipPrefixes {
+			listenAddrs = append(listenAddrs, prefix+fmt.Sprintf(suffix, port))
+		}
+	}
+
+	// A plain --transport=tcp, the default, builds the host as it always has
+	// and leaves libp2p's transport defaults alone; any other set replaces
+	// them outright.
+	var opts []libp2p.Option
+	if !slices.Equal(names, []string{"tcp"}) {
+		opts = append(opts, libp2p.NoTransports)
+	}
+	// tcp, ws and wss share one TCP listener, which tells their connections
+	// apart, so they can all use the same --port. udx and quic each need a
+	// UDP socket of their own.
+	tcpBased := 0
+	for _, name := range names {
+		if name == "tcp" || name == "ws" || name == "wss" {
+			tcpBased++
+		}
+	}
+	if tcpBased > 1 {
+		opts = append(opts, libp2p.ShareTCPListener())
+	}
+	if port != 0 && slices.Contains(names, "udx") && slices.Contains(names, "quic") {
+		return nil, fmt.Errorf("udx and quic can't both listen on UDP port %d; use --port=0", port)
+	}
+	// ws and wss are served by one WebSocket transport; wss adds the TLS config.
+	var wsOpts []any
+	for _, name := range names {
+		switch name {
+		case "tcp":
+			opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+			listenOn("/tcp/%d")
+		case "udx":
+			opts = append(opts, libp2p.Transport(udxtransport.NewTransport))
+			listenOn("/udp/%d/udx")
+		case "quic":
+			// QUIC brings its own TLS 1.3 handshake and stream muxing, so the
+			// Noise and Yamux options don't apply to these connections.
+			opts = append(opts, libp2p.Transport(libp2pquic.NewTransport))
+			listenOn("/udp/%d/quic-v1")
+		case "ws":
+			listenOn("/tcp/%d/ws")
+		case "wss":
+			tlsConf, err := selfSignedTLSConfig()
+			if err != nil {
+				return nil, fmt.Errorf("wss certificate: %w", err)
+			}
+			wsOpts = append(wsOpts, websocket.WithTLSConfig(tlsConf))
+			listenOn("/tcp/%d/tls/sni/localhost/ws")
+		}
+	}
+	if slices.Contains(names, "ws") || slices.Contains(names, "wss") {
+		wsOpts = append(wsOpts, wsClientOpt())
+		opts = append(opts, libp2p.Transport(websocket.New, wsOpts...))
+	}
+
+	return append(opts, libp2p.ListenAddrStrings(listenAddrs...)), nil
+}
+
+// wsClientOpt lets the WebSocket transport dial wss peers with self-signed
+// certificates, such as another go-peer. The libp2p handshake on top still
+// authenticates the remote peer.
+func wsClientOpt() websocket.Option {
+	return websocket.WithTLSClientConfig(&tls.Config{InsecureSkipVerify: true})
+}
+
+// selfSignedTLSConfig creates a certificate for localhost, 127.0.0.1 and ::1
+// valid for one day, and prints its SHA-256 fingerprint so the Dart side can
+// pin it.
+func selfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	fp := certFingerprint(der)
+	emit(Event{Type: "certificate", Message: fp}, "CertFingerprint: %s", fp)
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}, nil
+}
+
+// certFingerprint formats the SHA-256 of a DER certificate the way openssl
+// does (uppercase hex pairs separated by colons).
+func certFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
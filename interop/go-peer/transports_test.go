@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestParseTransports(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want []string // nil for an error
+	}{
+		{"tcp", []string{"tcp"}},
+		{"tcp,udx,quic", []string{"tcp", "udx", "quic"}},
+		{" ws , wss ", []string{"ws", "wss"}},
+		{"quic,tcp,quic", []string{"quic", "tcp"}},
+		{"tcp,,udx,", []string{"tcp", "udx"}},
+		{"", nil},
+		{" , ", nil},
+		{"tcp,webrtc", nil},
+		{"TCP", nil},
+	} {
+		got, err := parseTransports(tc.in)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestTransportsOnFixedPort(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	// tcp and ws share one listener on the port.
+	h, err := createHost(port, "tcp,ws", &PeerConfig{})
+	if err != nil {
+		t.Fatalf("tcp,ws on port %d: %v", port, err)
+	}
+	var addrs []string
+	for _, a := range h.Network().ListenAddresses() {
+		addrs = append(addrs, a.String())
+	}
+	h.Close()
+	for _, want := range []string{fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port), fmt.Sprintf("/ip4/0.0.0.0/tcp/%d/ws", port)} {
+		if !slices.Contains(addrs, want) {
+			t.Errorf("tcp,ws listens on %q, want %s", addrs, want)
+		}
+	}
+
+	// udx and quic would each bind the UDP port.
+	if _, err := transportOpts("udx,quic", port, false); err == nil {
+		t.Errorf("udx,quic on port %d: want an error", port)
+	}
+	if _, err := transportOpts("udx,quic", 0, false); err != nil {
+		t.Errorf("udx,quic on port 0: %v", err)
+	}
+}
+
+func TestTransportListenAddrs(t *testing.T) {
+	port := regexp.MustCompile(`/(tcp|udp)/\d+`)
+	for _, tc := range []struct {
+		transport string
+		ipv6      bool
+		want      []string
+	}{
+		{"tcp", false, []string{"/ip4/0.0.0.0/tcp/N"}},
+		{"tcp,udx", true, []string{
+			"/ip4/0.0.0.0/tcp/N", "/ip4/0.0.0.0/udp/N/udx",
+			"/ip6/::/tcp/N", "/ip6/::/udp/N/udx",
+		}},
+		{"quic,ws", false, []string{"/ip4/0.0.0.0/tcp/N/ws", "/ip4/0.0.0.0/udp/N/quic-v1"}},
+	} {
+		cfg := &PeerConfig{}
+		cfg.Listen.IPv6 = tc.ipv6
+		h, err := createHost(0, tc.transport, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.transport, err)
+		}
+		var got []string
+		for _, a := range h.Network().ListenAddresses() {
+			got = append(got, port.ReplaceAllString(a.String(), "/$1/N"))
+		}
+		h.Close()
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s ipv6=%t: listens on %q, want %q", tc.transport, tc.ipv6, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	yamux "github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	goyamux "github.com/libp2p/go-yamux/v5"
+)
+
+// yamuxMuxer returns the muxer for cfg: the plain yamux transport, or
+// yamuxWrapper when stream open or close timeouts, session stats or frame
+// tracing are enabled. go-yamux has no such timeouts itself.
+func yamuxMuxer(cfg *PeerConfig) (network.Multiplexer, error) {
+	tpt := yamuxTransport(cfg)
+	if err := goyamux.VerifyConfig(tpt.Config()); err != nil {
+		return nil, fmt.Errorf("yamux: %w", err)
+	}
+	if cfg == nil || (cfg.Yamux.StreamOpenTimeout == 0 && cfg.Yamux.StreamCloseTimeout == 0 &&
+		!cfg.Yamux.Stats && cfg.Yamux.StatsInterval == 0 && cfg.Yamux.Trace == "") {
+		return tpt, nil
+	}
+	m := &yamuxWrapper{
+		Transport:    tpt,
+		openTimeout:  time.Duration(cfg.Yamux.StreamOpenTimeout),
+		closeTimeout: time.Duration(cfg.Yamux.StreamCloseTimeout),
+		stats:        cfg.Yamux.Stats || cfg.Yamux.StatsInterval > 0 || cfg.Yamux.Trace != "",
+	}
+	if cfg.Yamux.Trace != "" {
+		var err error
+		if m.tracer, err = yamuxTraceTo(cfg.Yamux.Trace); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// yamuxWrapper is a yamux transport whose streams time out while opening
+// and closing, and whose sessions are tapped for statistics.
+type yamuxWrapper struct {
+	*yamux.Transport
+	openTimeout  time.Duration
+	closeTimeout time.Duration
+	stats        bool
+	tracer       *yamuxTracer
+}
+
+func (m *yamuxWrapper) NewConn(c net.Conn, isServer bool, scope network.PeerScope) (network.MuxedConn, error) {
+	var tap *yamuxSession
+	if m.stats {
+		tap = newYamuxSession(c, m.tracer)
+		c = tap.conn
+	}
+	mc, err := m.Transport.NewConn(c, isServer, scope)
+	if err != nil {
+		return nil, err
+	}
+	if tap != nil {
+		mc.As(&tap.sess)
+		tap.register()
+	}
+	return &yamuxConn{MuxedConn: mc, m: m, tap: tap}, nil
+}
+
+type yamuxConn struct {
+	network.MuxedConn
+	m   *yamuxWrapper
+	tap *yamuxSession
+}
+
+// OpenStream gives up after the open timeout. go-yamux blocks here while
+// accept_backlog streams are still waiting for the remote side's ACK, so this
+// is the time a new stream may wait to be acknowledged.
+func (c *yamuxConn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	if c.m.openTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.m.openTimeout)
+		defer cancel()
+	}
+	s, err := c.MuxedConn.OpenStream(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			emit(Event{Type: "stream_timeout", Message: "open"}, "StreamTimeout: open after %s", c.m.openTimeout)
+		}
+		return nil, err
+	}
+	return c.wrap(s), nil
+}
+
+// AcceptStream only fails once the session is over, with the reason it
+// ended.
+func (c *yamuxConn) AcceptStream() (network.MuxedStream, error) {
+	s, err := c.MuxedConn.AcceptStream()
+	if err != nil {
+		if c.tap != nil {
+			c.tap.closed(err)
+		}
+		return nil, err
+	}
+	return c.wrap(s), nil
+}
+
+func (c *yamuxConn) wrap(s network.MuxedStream) network.MuxedStream {
+	if c.m.closeTimeout == 0 {
+		return s
+	}
+	return &timeoutStream{MuxedStream: s, closeTimeout: c.m.closeTimeout}
+}
+
+// timeoutStream closes the way hashicorp/yamux does with a close timeout:
+// Close sends FIN and waits for the remote FIN in the background, resetting
+// the stream if it doesn't arrive in time. Data still arriving is discarded.
+type timeoutStream struct {
+	network.MuxedStream
+	closeTimeout time.Duration
+}
+
+func (s *timeoutStream) Close() error {
+	if err := s.MuxedStream.CloseWrite(); err != nil {
+		return s.MuxedStream.Close()
+	}
+	go func() {
+		s.MuxedStream.SetReadDeadline(time.Now().Add(s.closeTimeout))
+		if _, err := io.Copy(io.Discard, s.MuxedStream); err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				emit(Event{Type: "stream_timeout", Message: "close"}, "StreamTimeout: close after %s", s.closeTimeout)
+			}
+			s.MuxedStream.Reset()
+			return
+		}
+		s.MuxedStream.Close()
+	}()
+	return nil
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	goyamux "github.com/libp2p/go-yamux/v5"
+)
+
+// Yamux frame layout (spec: hashicorp/yamux/spec.md).
+const (
+	yamuxHeaderSize = 12
+
+	yamuxTypeData         = 0
+	yamuxTypeWindowUpdate = 1
+	yamuxTypePing         = 2
+	yamuxTypeGoAway       = 3
+
+	yamuxFlagSYN = 1
+	yamuxFlagACK = 2
+	yamuxFlagFIN = 4
+	yamuxFlagRST = 8
+
+	// yamuxInitialWindow is the window every stream starts with in both
+	// directions; larger windows are announced with window updates.
+	yamuxInitialWindow = 256 * 1024
+)
+
+// yamuxFrame is a decoded frame header. Length is the payload size of data
+// frames, the delta of window updates, the opaque value of pings and the
+// error code of GoAways.
+type yamuxFrame struct {
+	Version  uint8
+	Type     uint8
+	Flags    uint16
+	StreamID uint32
+	Length   uint32
+}
+
+// frameParser decodes frame headers from one direction of a session's byte
+// stream, however the bytes are split across reads or writes.
+type frameParser struct {
+	hdr     [yamuxHeaderSize]byte
+	n       int
+	payload uint32 // data bytes left to skip
+	onFrame func(yamuxFrame)
+}
+
+func (p *frameParser) feed(b []byte) {
+	for len(b) > 0 {
+		if p.payload > 0 {
+			skip := min(uint32(len(b)), p.payload)
+			p.payload -= skip
+			b = b[skip:]
+			continue
+		}
+		c := copy(p.hdr[p.n:], b)
+		p.n += c
+		b = b[c:]
+		if p.n < yamuxHeaderSize {
+			return
+		}
+		p.n = 0
+		f := yamuxFrame{
+			Version:  p.hdr[0],
+			Type:     p.hdr[1],
+			Flags:    binary.BigEndian.Uint16(p.hdr[2:4]),
+			StreamID: binary.BigEndian.Uint32(p.hdr[4:8]),
+			Length:   binary.BigEndian.Uint32(p.hdr[8:12]),
+		}
+		if f.Type == yamuxTypeData {
+			p.payload = f.Length
+		}
+		p.onFrame(f)
+	}
+}
+
+// tapConn feeds everything read from and written to a session's connection
+// to the session's frame parsers.
+type tapConn struct {
+	net.Conn
+	s *yamuxSession
+}
+
+func (c *tapConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.s.mu.Lock()
+		c.s.bytesIn += uint64(n)
+		c.s.in.feed(b[:n])
+		c.s.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *tapConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.s.mu.Lock()
+		c.s.bytesOut += uint64(n)
+		c.s.out.feed(b[:n])
+		c.s.mu.Unlock()
+	}
+	return n, err
+}
+
+// streamWindows is the flow control credit of one stream as both sides see
+// it: how much this peer may still send, and how much the remote may.
+type streamWindows struct {
+	send, recv int64
+	finIn      bool
+	finOut     bool
+}
+
+// SessionStats is a snapshot of one yamux session, reported as the stats
+// field of yamux_stats and yamux_session_closed events.
+type SessionStats struct {
+	Session       int            `json:"session"`
+	Streams       int            `json:"streams"`
+	BytesSent     uint64         `json:"bytes_sent"`
+	BytesReceived uint64         `json:"bytes_received"`
+	PingsSent     int            `json:"pings_sent"`
+	PongsReceived int            `json:"pongs_received"`
+	PingsReceived int            `json:"pings_received"`
+	PongsSent     int            `json:"pongs_sent"`
+	PingsPending  int            `json:"pings_pending"`
+	Windows       []StreamWindow `json:"windows,omitempty"`
+	GoAway        string         `json:"go_away,omitempty"`
+}
+
+// StreamWindow is the send and receive window of one open stream.
+type StreamWindow struct {
+	Stream uint32 `json:"stream"`
+	Send   int64  `json:"send"`
+	Recv   int64  `json:"recv"`
+}
+
+// yamuxSession follows one yamux session from its frames: stream windows,
+// keepalive pings and GoAways. Byte counts include frame headers.
+type yamuxSession struct {
+	id     int
+	peer   peer.ID
+	conn   net.Conn
+	sess   *goyamux.Session
+	tracer *yamuxTracer // nil unless --trace-yamux is set
+
+	mu                 sync.Mutex
+	in, out            frameParser
+	bytesIn, bytesOut  uint64
+	streams            map[uint32]*streamWindows
+	pings              map[uint32]time.Time // our pings awaiting a pong
+	pingsSent, pongsIn int
+	pingsIn, pongsOut  int
+	rtt                time.Duration
+	goAway             string
+	closeOnce          sync.Once
+}
+
+var (
+	yamuxSessionSeq atomic.Int64
+	yamuxSessions   sync.Map // id -> *yamuxSession
+)
+
+// newYamuxSession taps c, the secured connection a yamux session is about to
+// run on.
+func newYamuxSession(c net.Conn, tracer *yamuxTracer) *yamuxSession {
+	s := &yamuxSession{
+		id:      int(yamuxSessionSeq.Add(1)),
+		tracer:  tracer,
+		streams: map[uint32]*streamWindows{},
+		pings:   map[uint32]time.Time{},
+	}
+	if sc, ok := c.(interface{ RemotePeer() peer.ID }); ok {
+		s.peer = sc.RemotePeer()
+	}
+	s.in.onFrame = func(f yamuxFrame) { s.frame(f, true) }
+	s.out.onFrame = func(f yamuxFrame) { s.frame(f, false) }
+	s.conn = &tapConn{Conn: c, s: s}
+	return s
+}
+
+func (s *yamuxSession) register() {
+	yamuxSessions.Store(s.id, s)
+}
+
+// frame updates the session state for a frame received (in) or sent. Called
+// with s.mu held.
+func (s *yamuxSession) frame(f yamuxFrame, in bool) {
+	switch f.Type {
+	case yamuxTypeData, yamuxTypeWindowUpdate:
+		st := s.streams[f.StreamID]
+		if st == nil {
+			if f.Flags&yamuxFlagSYN == 0 {
+				s.traceFrame(f, in, nil) // a late frame of a stream that is already gone
+				return
+			}
+			st = &streamWindows{send: yamuxInitialWindow, recv: yamuxInitialWindow}
+			s.streams[f.StreamID] = st
+		}
+		switch {
+		case f.Type == yamuxTypeData && in:
+			st.recv -= int64(f.Length)
+		case f.Type == yamuxTypeData:
+			st.send -= int64(f.Length)
+		case in:
+			st.send += int64(f.Length)
+		default:
+			st.recv += int64(f.Length)
+		}
+		if f.Flags&yamuxFlagFIN != 0 {
+			if in {
+				st.finIn = true
+			} else {
+				st.finOut = true
+			}
+		}
+		if f.Flags&yamuxFlagRST != 0 || (st.finIn && st.finOut) {
+			delete(s.streams, f.StreamID)
+		}
+		s.traceFrame(f, in, st)
+		return
+	case yamuxTypePing:
+		switch {
+		case f.Flags&yamuxFlagSYN != 0 && in:
+			s.pingsIn++
+		case f.Flags&yamuxFlagSYN != 0:
+			s.pingsSent++
+			s.pings[f.Length] = time.Now()
+		case in:
+			s.pongsIn++
+			if sent, ok := s.pings[f.Length]; ok {
+				s.rtt = time.Since(sent)
+				delete(s.pings, f.Length)
+			}
+		default:
+			s.pongsOut++
+		}
+	case yamuxTypeGoAway:
+		side := "sent"
+		if in {
+			side = "received"
+		}
+		s.goAway = fmt.Sprintf("%s code=%d (%s)", side, f.Length, goAwayCodeName(f.Length))
+	}
+	s.traceFrame(f, in, nil)
+}
+
+func goAwayCodeName(code uint32) string {
+	switch code {
+	case 0:
+		return "normal"
+	case 1:
+		return "protocol error"
+	case 2:
+		return "internal error"
+	}
+	return "application code"
+}
+
+func (s *yamuxSession) snapshot() (SessionStats, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := SessionStats{
+		Session:       s.id,
+		BytesSent:     s.bytesOut,
+		BytesReceived: s.bytesIn,
+		PingsSent:     s.pingsSent,
+		PongsReceived: s.pongsIn,
+		PingsReceived: s.pingsIn,
+		PongsSent:     s.pongsOut,
+		PingsPending:  len(s.pings),
+		GoAway:        s.goAway,
+	}
+	if s.sess != nil {
+		st.Streams = s.sess.NumStreams()
+	}
+	for id, w := range s.streams {
+		st.Windows = append(st.Windows, StreamWindow{Stream: id, Send: w.send, Recv: w.recv})
+	}
+	slices.SortFunc(st.Windows, func(a, b StreamWindow) int { return int(a.Stream) - int(b.Stream) })
+	return st, s.rtt
+}
+
+// report prints the session's current statistics.
+func (s *yamuxSession) report() {
+	st, rtt := s.snapshot()
+	emit(Event{Type: "yamux_stats", Peer: s.peer.String(), RTTMs: msec(rtt), Stats: &st},
+		"YamuxStats: %s", formatSessionStats(s.peer, st, rtt))
+}
+
+// closed reports the end of the session once, with the reason go-yamux gave.
+func (s *yamuxSession) closed(err error) {
+	s.closeOnce.Do(func() {
+		yamuxSessions.Delete(s.id)
+		reason := sessionEndReason(err)
+		st, rtt := s.snapshot()
+		emit(Event{Type: "yamux_session_closed", Peer: s.peer.String(), RTTMs: msec(rtt), Message: reason, Stats: &st},
+			"YamuxSessionClosed: reason=%q %s", reason, formatSessionStats(s.peer, st, rtt))
+	})
+}
+
+// sessionEndReason names why a session ended from the error its
+// AcceptStream returned.
+func sessionEndReason(err error) string {
+	var ce *network.ConnError
+	switch {
+	case errors.Is(err, goyamux.ErrKeepAliveTimeout):
+		return "keepalive timeout"
+	case errors.Is(err, goyamux.ErrConnectionWriteTimeout):
+		return "connection write timeout"
+	case errors.As(err, &ce) && ce.Remote:
+		return fmt.Sprintf("remote GoAway code=%d (%s)", ce.ErrorCode, goAwayCodeName(uint32(ce.ErrorCode)))
+	case errors.As(err, &ce) && ce.ErrorCode == 0:
+		return "closed locally"
+	case errors.As(err, &ce):
+		return fmt.Sprintf("local GoAway code=%d (%s)", ce.ErrorCode, goAwayCodeName(uint32(ce.ErrorCode)))
+	}
+	return "connection error: " + err.Error()
+}
+
+func formatSessionStats(p peer.ID, st SessionStats, rtt time.Duration) string {
+	var windows []string
+	for _, w := range st.Windows {
+		windows = append(windows, fmt.Sprintf("%d:%d/%d", w.Stream, w.Send, w.Recv))
+	}
+	text := fmt.Sprintf("session=%d peer=%s streams=%d sent=%d recv=%d rtt=%.2fms pings=%d/%d pongs=%d/%d pending=%d windows=[%s]",
+		st.Session, p, st.Streams, st.BytesSent, st.BytesReceived, msec(rtt),
+		st.PingsSent, st.PongsReceived, st.PingsReceived, st.PongsSent, st.PingsPending, strings.Join(windows, " "))
+	if st.GoAway != "" {
+		text += " goaway=" + st.GoAway
+	}
+	return text
+}
+
+// reportYamuxSessions prints the statistics of every open session.
+func reportYamuxSessions() {
+	yamuxSessions.Range(func(_, v any) bool {
+		v.(*yamuxSession).report()
+		return true
+	})
+}
+
+// startYamuxStats registers the "yamux-stats" stdin command and, with an
+// interval, reports all sessions periodically.
+func startYamuxStats(cfg *PeerConfig) {
+	if cfg == nil || (!cfg.Yamux.Stats && cfg.Yamux.StatsInterval == 0) {
+		return
+	}
+	registerStdinCommand("yamux-stats", func([]string) error {
+		reportYamuxSessions()
+		return nil
+	})
+	if interval := time.Duration(cfg.Yamux.StatsInterval); interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				reportYamuxSessions()
+			}
+		}()
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// encodeFrame returns the wire form of f followed by payload.
+func encodeFrame(f yamuxFrame, payload []byte) []byte {
+	b := make([]byte, yamuxHeaderSize, yamuxHeaderSize+len(payload))
+	b[0], b[1] = f.Version, f.Type
+	binary.BigEndian.PutUint16(b[2:4], f.Flags)
+	binary.BigEndian.PutUint32(b[4:8], f.StreamID)
+	binary.BigEndian.PutUint32(b[8:12], f.Length)
+	return append(b, payload...)
+}
+
+func TestFrameParser(t *testing.T) {
+	frames := []yamuxFrame{
+		{Type: yamuxTypeWindowUpdate, Flags: yamuxFlagSYN, StreamID: 1, Length: 0},
+		{Type: yamuxTypeData, StreamID: 1, Length: 20},
+		{Type: yamuxTypeData, Flags: yamuxFlagFIN, StreamID: 1, Length: 0},
+		{Type: yamuxTypePing, Flags: yamuxFlagSYN, Length: 7},
+		{Type: yamuxTypeWindowUpdate, Flags: yamuxFlagACK, StreamID: 3, Length: 1 << 20},
+		{Type: yamuxTypeGoAway, Length: 2},
+	}
+	var stream []byte
+	for _, f := range frames {
+		var payload []byte
+		if f.Type == yamuxTypeData {
+			// Payload bytes that look like a header must be skipped.
+			payload = encodeFrame(yamuxFrame{Type: yamuxTypePing, Length: 99}, nil)[:f.Length%13]
+			payload = append(payload, make([]byte, int(f.Length)-len(payload))...)
+		}
+		stream = append(stream, encodeFrame(f, payload)...)
+	}
+
+	for _, split := range []int{len(stream), 1, 5, 12, 13, 31} {
+		var got []yamuxFrame
+		p := frameParser{onFrame: func(f yamuxFrame) { got = append(got, f) }}
+		for b := stream; len(b) > 0; {
+			n := min(split, len(b))
+			p.feed(b[:n])
+			b = b[n:]
+		}
+		if !slices.Equal(got, frames) {
+			t.Errorf("split %d: got %+v, want %+v", split, got, frames)
+		}
+		if p.n != 0 || p.payload != 0 {
+			t.Errorf("split %d: parser left mid-frame (header %d, payload %d)", split, p.n, p.payload)
+		}
+	}
+}
+
+func TestSessionWindows(t *testing.T) {
+	type step struct {
+		f  yamuxFrame
+		in bool
+	}
+	data := func(id, n uint32, flags uint16) yamuxFrame {
+		return yamuxFrame{Type: yamuxTypeData, Flags: flags, StreamID: id, Length: n}
+	}
+	update := func(id, n uint32, flags uint16) yamuxFrame {
+		return yamuxFrame{Type: yamuxTypeWindowUpdate, Flags: flags, StreamID: id, Length: n}
+	}
+	const w = yamuxInitialWindow
+	for _, tc := range []struct {
+		name  string
+		steps []step
+		want  []StreamWindow
+	}{
+		{
+			name:  "open and send",
+			steps: []step{{update(1, 0, yamuxFlagSYN), false}, {data(1, 100, 0), false}},
+			want:  []StreamWindow{{Stream: 1, Send: w - 100, Recv: w}},
+		},
+		{
+			name: "receive and grant",
+			steps: []step{
+				{data(2, 1000, yamuxFlagSYN), true},
+				{update(2, 1000, yamuxFlagACK), false},
+				{data(2, 500, 0), true},
+			},
+			want: []StreamWindow{{Stream: 2, Send: w, Recv: w - 500}},
+		},
+		{
+			name: "remote grows window",
+			steps: []step{
+				{update(1, 0, yamuxFlagSYN), false},
+				{update(1, 1<<20, yamuxFlagACK), true},
+				{data(1, 4096, 0), false},
+			},
+			want: []StreamWindow{{Stream: 1, Send: w + 1<<20 - 4096, Recv: w}},
+		},
+		{
+			name: "half close keeps stream",
+			steps: []step{
+				{update(1, 0, yamuxFlagSYN), false},
+				{data(1, 0, yamuxFlagFIN), false},
+			},
+			want: []StreamWindow{{Stream: 1, Send: w, Recv: w}},
+		},
+		{
+			name: "both fins remove stream",
+			steps: []step{
+				{update(1, 0, yamuxFlagSYN), false},
+				{data(1, 0, yamuxFlagFIN), false},
+				{update(1, 0, yamuxFlagFIN), true},
+			},
+		},
+		{
+			name: "reset removes stream",
+			steps: []step{
+				{update(1, 0, yamuxFlagSYN), false},
+				{update(3, 0, yamuxFlagSYN), true},
+				{update(1, 0, yamuxFlagRST), true},
+			},
+			want: []StreamWindow{{Stream: 3, Send: w, Recv: w}},
+		},
+		{
+			name:  "late frame ignored",
+			steps: []step{{data(5, 100, 0), true}},
+		},
+	} {
+		s := newYamuxSession(nil, nil)
+		for _, st := range tc.steps {
+			s.frame(st.f, st.in)
+		}
+		got, _ := s.snapshot()
+		if !slices.Equal(got.Windows, tc.want) {
+			t.Errorf("%s: windows %+v, want %+v", tc.name, got.Windows, tc.want)
+		}
+	}
+}
+
+func TestSessionPingsAndGoAway(t *testing.T) {
+	ping := func(flags uint16, opaque uint32) yamuxFrame {
+		return yamuxFrame{Type: yamuxTypePing, Flags: flags, Length: opaque}
+	}
+	s := newYamuxSession(nil, nil)
+	s.frame(ping(yamuxFlagSYN, 1), false)
+	s.frame(ping(yamuxFlagSYN, 2), false)
+	s.frame(ping(yamuxFlagACK, 1), true)
+	s.frame(ping(yamuxFlagSYN, 9), true)
+	s.frame(ping(yamuxFlagACK, 9), false)
+	s.frame(yamuxFrame{Type: yamuxTypeGoAway, Length: 1}, true)
+
+	st, rtt := s.snapshot()
+	want := SessionStats{
+		Session:       s.id,
+		PingsSent:     2,
+		PongsReceived: 1,
+		PingsReceived: 1,
+		PongsSent:     1,
+		PingsPending:  1,
+		GoAway:        "received code=1 (protocol error)",
+	}
+	if !reflect.DeepEqual(st, want) {
+		t.Errorf("got %+v, want %+v", st, want)
+	}
+	if rtt <= 0 {
+		t.Errorf("rtt %v, want the time to the first pong", rtt)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// FrameTrace is one decoded yamux frame, the frame field of yamux_frame
+// events and the lines of a JSON trace file.
+type FrameTrace struct {
+	Timestamp time.Time `json:"timestamp,omitzero"`
+	Session   int       `json:"session"`
+	Peer      string    `json:"peer,omitempty"`
+	Direction string    `json:"direction"` // "in" or "out"
+	Type      string    `json:"type"`
+	Flags     []string  `json:"flags,omitempty"`
+	Stream    uint32    `json:"stream"`
+	Length    uint32    `json:"length"`
+	// Window credit of the stream after a data or window update frame.
+	SendWindow *int64 `json:"send_window,omitempty"`
+	RecvWindow *int64 `json:"recv_window,omitempty"`
+}
+
+var yamuxTypeNames = []string{"DATA", "WINDOW_UPDATE", "PING", "GO_AWAY"}
+
+func yamuxFlagNames(flags uint16) []string {
+	var names []string
+	for i, name := range []string{"SYN", "ACK", "FIN", "RST"} {
+		if flags&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// yamuxTraceQueue is how many frames may wait for the trace writer before
+// further frames are dropped.
+const yamuxTraceQueue = 16384
+
+// yamuxTracer writes every frame of every tapped session to a file, as text
+// or (for .json and .jsonl paths) JSON lines, or with path "-" reports them
+// as yamux_frame events on stdout. Sessions only queue frames and one
+// goroutine writes them, so a slow trace reader never stalls a session.
+// Frames that don't fit in the queue are counted and reported as dropped.
+type yamuxTracer struct {
+	w       io.Writer
+	json    bool
+	queue   chan traceRecord
+	dropped atomic.Int64
+}
+
+// traceRecord is a queued frame, or with flushed set a marker the writer
+// closes once everything queued before it is written.
+type traceRecord struct {
+	ft      FrameTrace
+	flushed chan struct{}
+}
+
+var (
+	yamuxTracersMu sync.Mutex
+	yamuxTracers   = map[string]*yamuxTracer{}
+)
+
+// yamuxTraceTo returns the tracer for path. Every host of the process shares
+// it, so the file is created only once.
+func yamuxTraceTo(path string) (*yamuxTracer, error) {
+	yamuxTracersMu.Lock()
+	defer yamuxTracersMu.Unlock()
+	if t, ok := yamuxTracers[path]; ok {
+		return t, nil
+	}
+	t := &yamuxTracer{queue: make(chan traceRecord, yamuxTraceQueue)}
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("yamux trace file: %w", err)
+		}
+		t.w = f
+		t.json = strings.HasSuffix(path, ".json") || strings.HasSuffix(path, ".jsonl")
+	}
+	yamuxTracers[path] = t
+	go t.run()
+	return t, nil
+}
+
+// trace queues ft without blocking, dropping it if the queue is full.
+func (t *yamuxTracer) trace(ft FrameTrace) {
+	select {
+	case t.queue <- traceRecord{ft: ft}:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *yamuxTracer) run() {
+	for r := range t.queue {
+		if r.flushed != nil {
+			t.reportDropped()
+			close(r.flushed)
+			continue
+		}
+		t.write(r.ft)
+		if len(t.queue) == 0 {
+			t.reportDropped()
+		}
+	}
+}
+
+func (t *yamuxTracer) write(ft FrameTrace) {
+	if t.w == nil {
+		emit(Event{Type: "yamux_frame", Peer: ft.Peer, Frame: &ft}, "YamuxFrame: %s", formatFrame(ft))
+		return
+	}
+	if t.json {
+		if err := json.NewEncoder(t.w).Encode(ft); err != nil {
+			fmt.Fprintf(os.Stderr, "Write trace error: %v\n", err)
+		}
+		return
+	}
+	fmt.Fprintf(t.w, "%s %s\n", ft.Timestamp.Format(time.RFC3339Nano), formatFrame(ft))
+}
+
+// reportDropped notes in the trace how many frames were dropped since the
+// last note, once the writer has caught up.
+func (t *yamuxTracer) reportDropped() {
+	n := t.dropped.Swap(0)
+	if n == 0 {
+		return
+	}
+	now := time.Now()
+	switch {
+	case t.w == nil:
+		emit(Event{Type: "yamux_trace_dropped", Message: fmt.Sprintf("%d frames", n)}, "YamuxTraceDropped: %d frames", n)
+	case t.json:
+		json.NewEncoder(t.w).Encode(struct {
+			Timestamp time.Time `json:"timestamp"`
+			Dropped   int64     `json:"dropped"`
+		}{now, n})
+	default:
+		fmt.Fprintf(t.w, "%s dropped %d frames\n", now.Format(time.RFC3339Nano), n)
+	}
+}
+
+// flushYamuxTraces waits, up to a second, until every frame traced so far is
+// written, so nothing queued is lost when the process exits.
+func flushYamuxTraces() {
+	yamuxTracersMu.Lock()
+	tracers := make([]*yamuxTracer, 0, len(yamuxTracers))
+	for _, t := range yamuxTracers {
+		tracers = append(tracers, t)
+	}
+	yamuxTracersMu.Unlock()
+
+	deadline := time.After(time.Second)
+	for _, t := range tracers {
+		done := make(chan struct{})
+		select {
+		case t.queue <- traceRecord{flushed: done}:
+		case <-deadline:
+			return
+		}
+		select {
+		case <-done:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func formatFrame(ft FrameTrace) string {
+	flags := "-"
+	if len(ft.Flags) > 0 {
+		flags = strings.Join(ft.Flags, "|")
+	}
+	text := fmt.Sprintf("session=%d %-3s %-13s stream=%d flags=%s length=%d",
+		ft.Session, ft.Direction, ft.Type, ft.Stream, flags, ft.Length)
+	if ft.SendWindow != nil {
+		text += fmt.Sprintf(" window=%d/%d", *ft.SendWindow, *ft.RecvWindow)
+	}
+	return text + " peer=" + ft.Peer
+}
+
+// traceFrame queues f with the session's tracer. st is the stream's window
+// state after the frame, if it is a tracked stream. Called with s.mu held.
+func (s *yamuxSession) traceFrame(f yamuxFrame, in bool, st *streamWindows) {
+	if s.tracer == nil {
+		return
+	}
+	ft := FrameTrace{
+		Timestamp: time.Now(),
+		Session:   s.id,
+		Peer:      s.peer.String(),
+		Direction: "out",
+		Type:      fmt.Sprintf("TYPE_%d", f.Type),
+		Flags:     yamuxFlagNames(f.Flags),
+		Stream:    f.StreamID,
+		Length:    f.Length,
+	}
+	if in {
+		ft.Direction = "in"
+	}
+	if int(f.Type) < len(yamuxTypeNames) {
+		ft.Type = yamuxTypeNames[f.Type]
+	}
+	if st != nil {
+		send, recv := st.send, st.recv
+		ft.SendWindow, ft.RecvWindow = &send, &recv
+	}
+	s.tracer.trace(ft)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestYamuxTraceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace.jsonl")
+	tr, err := yamuxTraceTo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		yamuxTracersMu.Lock()
+		delete(yamuxTracers, path)
+		yamuxTracersMu.Unlock()
+	})
+
+	s := newYamuxSession(nil, tr)
+	s.frame(yamuxFrame{Type: yamuxTypeWindowUpdate, Flags: yamuxFlagSYN, StreamID: 1}, false)
+	s.frame(yamuxFrame{Type: yamuxTypeData, StreamID: 1, Length: 100}, true)
+	s.frame(yamuxFrame{Type: yamuxTypePing, Flags: yamuxFlagSYN, Length: 3}, false)
+	flushYamuxTraces()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []FrameTrace
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var ft FrameTrace
+		if err := json.Unmarshal(sc.Bytes(), &ft); err != nil {
+			t.Fatalf("%s: %v", sc.Text(), err)
+		}
+		got = append(got, ft)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d frames, want 3: %+v", len(got), got)
+	}
+	if got[0].Type != "WINDOW_UPDATE" || got[0].Direction != "out" || got[0].Flags[0] != "SYN" {
+		t.Errorf("frame 0: %+v", got[0])
+	}
+	if got[1].Type != "DATA" || got[1].Direction != "in" || got[1].RecvWindow == nil || *got[1].RecvWindow != yamuxInitialWindow-100 {
+		t.Errorf("frame 1: %+v", got[1])
+	}
+	if got[2].Type != "PING" || got[2].Length != 3 || got[2].SendWindow != nil || got[2].Timestamp.IsZero() {
+		t.Errorf("frame 2: %+v", got[2])
+	}
+}
+
+func TestYamuxTraceDrops(t *testing.T) {
+	var buf bytes.Buffer
+	tr := &yamuxTracer{w: &buf, queue: make(chan traceRecord, 2)}
+	// The writer isn't running yet, so only two frames fit.
+	for i := range 5 {
+		tr.trace(FrameTrace{Type: "PING", Length: uint32(i)})
+	}
+	if n := tr.dropped.Load(); n != 3 {
+		t.Fatalf("dropped %d, want 3", n)
+	}
+
+	go tr.run()
+	done := make(chan struct{})
+	tr.queue <- traceRecord{flushed: done}
+	<-done
+	close(tr.queue)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "length=0") || !strings.Contains(lines[1], "length=1") ||
+		!strings.HasSuffix(lines[2], " dropped 3 frames") {
+		t.Errorf("trace:\n%s", buf.String())
+	}
+	if n := tr.dropped.Load(); n != 0 {
+		t.Errorf("dropped %d after the report, want 0", n)
+	}
+}
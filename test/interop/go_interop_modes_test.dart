@@ -0,0 +1,388 @@
+import 'dart:io';
+import 'dart:math';
+import 'dart:typed_data';
+
+import 'package:dart_libp2p/core/crypto/ed25519.dart' as crypto_ed25519;
+import 'package:dart_libp2p/core/crypto/keys.dart';
+import 'package:dart_libp2p/core/multiaddr.dart';
+import 'package:dart_libp2p/core/network/conn.dart';
+import 'package:dart_libp2p/core/network/stream.dart';
+import 'package:dart_libp2p/core/network/transport_conn.dart';
+import 'package:dart_libp2p/core/peer/peer_id.dart';
+import 'package:dart_libp2p/core/peer/addr_info.dart';
+import 'package:dart_libp2p/core/network/context.dart' as core_context;
+import 'package:dart_libp2p/config/config.dart' as p2p_config;
+import 'package:dart_libp2p/config/stream_muxer.dart';
+import 'package:dart_libp2p/p2p/host/basic/basic_host.dart';
+import 'package:dart_libp2p/p2p/host/resource_manager/resource_manager_impl.dart';
+import 'package:dart_libp2p/p2p/host/resource_manager/limiter.dart';
+import 'package:dart_libp2p/p2p/security/noise/noise_protocol.dart';
+import 'package:dart_libp2p/p2p/transport/connection_manager.dart';
+import 'package:dart_libp2p/p2p/transport/multiplexing/multiplexer.dart';
+import 'package:dart_libp2p/p2p/transport/multiplexing/yamux/session.dart';
+import 'package:dart_libp2p/p2p/transport/tcp_transport.dart';
+import 'package:logging/logging.dart';
+import 'package:test/test.dart';
+
+import 'helpers/go_process_manager.dart';
+
+/// Yamux muxer provider for Config.
+class _TestYamuxMuxerProvider extends StreamMuxer {
+  _TestYamuxMuxerProvider({required MultiplexerConfig yamuxConfig})
+      : super(
+          id: YamuxConstants.protocolId,
+          muxerFactory: (Conn secureConn, bool isClient) {
+            if (secureConn is! TransportConn) {
+              throw ArgumentError(
+                  'YamuxMuxer factory expects a TransportConn, got ${secureConn.runtimeType}');
+            }
+            return YamuxSession(secureConn, yamuxConfig, isClient);
+          },
+        );
+}
+
+/// Exercises the Go peer's test modes against a Dart BasicHost, one test
+/// per mode or more where a mode has distinct cases.
+void main() {
+  Logger.root.level = Level.INFO;
+  Logger.root.onRecord.listen((record) {
+    if (record.level >= Level.WARNING) {
+      print('${record.level.name}: ${record.loggerName}: ${record.message}');
+    }
+  });
+
+  late String goBinaryPath;
+
+  final yamuxConfig = MultiplexerConfig(
+    keepAliveInterval: Duration(seconds: 30),
+    maxStreamWindowSize: 1024 * 1024,
+    initialStreamWindowSize: 256 * 1024,
+    streamWriteTimeout: Duration(seconds: 10),
+    maxStreams: 256,
+  );
+
+  setUpAll(() async {
+    final goSourceDir = '${Directory.current.path}/interop/go-peer';
+    goBinaryPath = await GoProcessManager.ensureBinary(goSourceDir);
+    print('Go peer binary: $goBinaryPath');
+  });
+
+  /// Creates a BasicHost with TCP transport via Config.newNode().
+  Future<BasicHost> createHost(KeyPair keyPair,
+      {List<MultiAddr>? listenAddrs}) async {
+    final connMgr = ConnectionManager();
+    final resMgr = ResourceManagerImpl(limiter: FixedLimiter());
+    final muxerDef = _TestYamuxMuxerProvider(yamuxConfig: yamuxConfig);
+
+    final config = p2p_config.Config()
+      ..peerKey = keyPair
+      ..securityProtocols = [await NoiseSecurity.create(keyPair)]
+      ..muxers = [muxerDef]
+      ..transports = [
+        TCPTransport(resourceManager: resMgr, connManager: connMgr)
+      ]
+      ..connManager = connMgr
+      ..addrsFactory = (addrs) => addrs; // Preserve loopback addresses
+
+    if (listenAddrs != null) {
+      config.listenAddrs = listenAddrs;
+    }
+
+    final host = await config.newNode() as BasicHost;
+    await host.start();
+    return host;
+  }
+
+  Uint8List randomBytes(int size) {
+    final random = Random();
+    return Uint8List.fromList(List.generate(size, (_) => random.nextInt(256)));
+  }
+
+  /// Reads until EOF, a reset or an error and returns everything read.
+  Future<Uint8List> readAll(P2PStream stream) async {
+    final buf = BytesBuilder(copy: false);
+    while (true) {
+      try {
+        final chunk = await stream.read();
+        if (chunk.isEmpty) break;
+        buf.add(chunk);
+      } catch (_) {
+        break;
+      }
+    }
+    return buf.takeBytes();
+  }
+
+  /// Echoes every chunk as it arrives and closes the write side at EOF, so
+  /// writers that keep writing while they read (stream-stress, scenarios)
+  /// get all of their data back.
+  Future<void> streamingEcho(P2PStream stream, PeerId remotePeer) async {
+    try {
+      while (true) {
+        final Uint8List chunk;
+        try {
+          chunk = await stream.read();
+        } catch (_) {
+          break;
+        }
+        if (chunk.isEmpty) break;
+        await stream.write(chunk);
+      }
+      await stream.closeWrite();
+    } catch (e) {
+      print('Echo handler error: $e');
+      await stream.reset();
+    }
+  }
+
+  group('Go test modes', () {
+    late GoProcessManager goProcess;
+    BasicHost? dartHost;
+
+    setUp(() {
+      goProcess = GoProcessManager(binaryPath: goBinaryPath, jsonOutput: true);
+    });
+
+    tearDown(() async {
+      await goProcess.stop();
+      if (dartHost != null) {
+        await dartHost!.close();
+        dartHost = null;
+      }
+    });
+
+    /// Starts a Dart host on loopback TCP with a streaming echo handler and
+    /// returns its full multiaddr for the Go side to dial.
+    Future<String> listenWithEcho() async {
+      final keyPair = await crypto_ed25519.generateEd25519KeyPair();
+      final dartPeerId = await PeerId.fromPublicKey(keyPair.publicKey);
+      dartHost = await createHost(keyPair,
+          listenAddrs: [MultiAddr('/ip4/127.0.0.1/tcp/0')]);
+      dartHost!.setStreamHandler('/echo/1.0.0', streamingEcho);
+
+      final dartAddr = dartHost!.addrs.firstWhere(
+        (addr) => addr.toString().contains('/tcp/'),
+        orElse: () => throw Exception('Dart host not listening on TCP'),
+      );
+      return '$dartAddr/p2p/${dartPeerId.toBase58()}';
+    }
+
+    /// Connects a new Dart host to the running Go peer.
+    Future<void> connectToGo() async {
+      final keyPair = await crypto_ed25519.generateEd25519KeyPair();
+      dartHost = await createHost(keyPair);
+      await dartHost!.connect(
+          AddrInfo(goProcess.peerId, [goProcess.listenAddr]),
+          context: core_context.Context());
+    }
+
+    test('Go daemon connects, echoes and pings Dart BasicHost', () async {
+      final target = await listenWithEcho();
+      await goProcess.startDaemon();
+
+      final connect = await goProcess.command('connect', args: {'target': target});
+      expect(connect['ok'], isTrue, reason: '${connect['error']}');
+
+      final echo = await goProcess
+          .command('echo', args: {'target': target, 'data': 'hello from daemon'});
+      expect(echo['ok'], isTrue, reason: '${echo['error']}');
+      expect(echo['result']['data'], 'hello from daemon');
+
+      final ping = await goProcess.command('ping', args: {'target': target});
+      expect(ping['ok'], isTrue, reason: '${ping['error']}');
+      expect(ping['result']['rtt_ms'], isA<num>());
+    }, timeout: Timeout(Duration(seconds: 60)));
+
+    test('Go p2pd answers IDENTIFY on its control socket', () async {
+      final dir = await Directory.systemTemp.createTemp('go_p2pd_');
+      addTearDown(() => dir.delete(recursive: true));
+      final socketPath = '${dir.path}/p2pd.sock';
+      await goProcess.startP2pd(socketPath: socketPath);
+
+      final socket = await Socket.connect(
+          InternetAddress(socketPath, type: InternetAddressType.unix), 0);
+      addTearDown(socket.destroy);
+      // A varint-delimited Request{type: IDENTIFY}.
+      socket.add([0x02, 0x08, 0x00]);
+      await socket.flush();
+
+      final response = BytesBuilder();
+      (int, int)? header;
+      await for (final chunk in socket.timeout(Duration(seconds: 10))) {
+        response.add(chunk);
+        header = _readVarint(response.toBytes());
+        if (header != null && response.length >= header.$1 + header.$2) break;
+      }
+      expect(header, isNotNull, reason: 'p2pd sent no response');
+      final (length, offset) = header!;
+      final body = response.toBytes().sublist(offset, offset + length);
+      // Response{type: OK, identify: {id: ..., addrs: ...}}
+      expect(body.sublist(0, 2), [0x08, 0x00]);
+      final id = goProcess.peerId.toBytes();
+      final found = Iterable<int>.generate(body.length - id.length + 1).any(
+          (i) => _listEquals(body.sublist(i, i + id.length), id));
+      expect(found, isTrue, reason: 'IDENTIFY should carry the Go peer ID');
+    }, timeout: Timeout(Duration(seconds: 30)));
+
+    test('Go scenario echoes through Dart BasicHost', () async {
+      final target = await listenWithEcho();
+      final dir = await Directory.systemTemp.createTemp('go_scenario_');
+      addTearDown(() => dir.delete(recursive: true));
+      final scenario = File('${dir.path}/echo.yaml');
+      await scenario.writeAsString('''
+name: dart-echo
+steps:
+  - action: connect
+    timeout: 10s
+  - action: wait-identify
+  - action: assert-peerstore
+    protocols: [/echo/1.0.0]
+  - action: open-stream
+    protocol: /echo/1.0.0
+  - action: send
+    bytes: 4096
+  - action: expect-echo
+    timeout: 5s
+  - action: disconnect
+''');
+
+      final report = await goProcess.runScenario(scenario.path, targetMultiaddr: target);
+      print('Scenario report: $report');
+      expect(report['passed'], isTrue);
+      expect(report['steps'], hasLength(7));
+    }, timeout: Timeout(Duration(seconds: 60)));
+
+    test('close-behavior reset ends the Dart stream after the echoed bytes',
+        () async {
+      await goProcess.startCloseBehaviorServer(
+          action: 'reset', afterBytes: 16, observe: Duration(seconds: 1));
+      await connectToGo();
+
+      final stream = await dartHost!.newStream(
+          goProcess.peerId, ['/echo/1.0.0'], core_context.Context());
+      final data = randomBytes(64);
+      await stream.write(data);
+      final echoed = await readAll(stream);
+      expect(echoed.length, lessThanOrEqualTo(16));
+      expect(echoed, orderedEquals(data.sublist(0, echoed.length)));
+
+      final action = await goProcess.waitForEvent('close_action');
+      expect(action['message'], 'reset');
+      expect(action['bytes'], 16);
+    }, timeout: Timeout(Duration(seconds: 30)));
+
+    test('close-behavior goaway-protocol closes the Dart connection', () async {
+      await goProcess.startCloseBehaviorServer(
+          action: 'goaway-protocol', observe: Duration(seconds: 1));
+      await connectToGo();
+
+      final stream = await dartHost!.newStream(
+          goProcess.peerId, ['/echo/1.0.0'], core_context.Context());
+      await stream.write(randomBytes(8));
+      await readAll(stream);
+
+      final action = await goProcess.waitForEvent('close_action');
+      expect(action['message'], 'goaway-protocol');
+      final closed = await goProcess.waitForEvent('close_observed',
+          where: (e) => e['message'] == 'connection closed');
+      expect(closed['elapsed_ms'], isA<num>());
+    }, timeout: Timeout(Duration(seconds: 30)));
+
+    test('Dart writer blocks on and recovers from a paused slow echo server',
+        () async {
+      // More than the 256 KiB yamux window, so the Dart writer has to wait
+      // for the Go reader to resume and send a window update.
+      const size = 512 * 1024;
+      await goProcess.startSlowEchoServer(
+          readRate: 1024 * 1024,
+          pause: Duration(seconds: 1),
+          pauseAfter: 4096);
+      await connectToGo();
+
+      final stream = await dartHost!.newStream(
+          goProcess.peerId, ['/echo/1.0.0'], core_context.Context());
+      final data = randomBytes(size);
+      final reader = readAll(stream);
+      for (var off = 0; off < size; off += 16 * 1024) {
+        await stream.write(data.sublist(off, min(off + 16 * 1024, size)));
+      }
+      await stream.closeWrite();
+      expect(await reader, orderedEquals(data));
+
+      await goProcess.waitForEvent('slow_echo',
+          where: (e) => (e['message'] as String).startsWith('paused'));
+      final done = await goProcess.waitForEvent('slow_echo',
+          where: (e) => e['message'] == 'done');
+      expect(done['bytes'], size);
+      await stream.close();
+    }, timeout: Timeout(Duration(seconds: 60)));
+
+    test('Go stream-stress against Dart echo handler', () async {
+      final target = await listenWithEcho();
+
+      final result = await goProcess.runStreamStress(target,
+          streams: 20, concurrency: 5, payloadSize: 32 * 1024, payloads: 4);
+      print('Go stream-stress stderr: ${result.stderr}');
+
+      expect(result.exitCode, 0, reason: 'every stress stream should echo');
+      expect(result.streams, 20);
+      expect(result.failed, 0);
+      expect(result.peakOpen, inInclusiveRange(1, 5));
+      expect(result.bytesEchoed, 20 * 32 * 1024 * 4);
+      expect(result.p50Ms, lessThanOrEqualTo(result.maxMs));
+    }, timeout: Timeout(Duration(seconds: 120)));
+
+    test('Dart perf client against Go perf server', () async {
+      const upload = 1 << 20;
+      const download = 2 << 20;
+      await goProcess.startPerfServer();
+      await connectToGo();
+
+      final stream = await dartHost!.newStream(
+          goProcess.peerId, ['/perf/1.0.0'], core_context.Context());
+      final header = ByteData(8)..setUint64(0, download);
+      await stream.write(header.buffer.asUint8List());
+      final block = Uint8List(64 * 1024);
+      for (var sent = 0; sent < upload; sent += block.length) {
+        await stream.write(block);
+      }
+      await stream.closeWrite();
+      final got = await readAll(stream);
+      expect(got.length, download);
+
+      final served = await goProcess.waitForEvent('perf_served');
+      expect(served['bytes'], download);
+      expect(served['message'], contains('received $upload bytes'));
+    }, timeout: Timeout(Duration(seconds: 60)));
+
+    test('Go ping rounds report statistics for Dart BasicHost', () async {
+      final target = await listenWithEcho();
+
+      final result = await goProcess.runPing(target,
+          count: 3, interval: Duration(milliseconds: 200));
+      print('Go ping stdout: ${result.stdout}');
+
+      expect(result.exitCode, 0, reason: 'Go ping should succeed');
+      expect(result.stdout.toString(), contains('PingStats: sent=3 received=3'));
+    }, timeout: Timeout(Duration(seconds: 30)));
+  });
+}
+
+bool _listEquals(List<int> a, List<int> b) {
+  if (a.length != b.length) return false;
+  for (var i = 0; i < a.length; i++) {
+    if (a[i] != b[i]) return false;
+  }
+  return true;
+}
+
+/// Decodes the varint at the start of [b] as (value, size), or null if [b]
+/// ends inside it.
+(int, int)? _readVarint(List<int> b) {
+  var value = 0;
+  for (var i = 0; i < b.length && i < 10; i++) {
+    value |= (b[i] & 0x7f) << (7 * i);
+    if (b[i] < 0x80) return (value, i + 1);
+  }
+  return null;
+}
@@ -8,16 +8,114 @@ import 'package:dart_libp2p/core/peer/peer_id.dart';
 /// Manages a go-libp2p test peer process for interop testing.
 class GoProcessManager {
   final String binaryPath;
+
+  /// Starts long-running peers with `--output=json` and parses their typed
+  /// events instead of the text markers.
+  final bool jsonOutput;
+
+  /// Passed as `--seed` to long-running peers and scenarios so their PeerID
+  /// is the same on every run.
+  final String? seed;
+
+  /// Passed as `--key-file` to long-running peers and scenarios; the key is
+  /// created on first start and reused afterwards, e.g. to restart with the
+  /// same PeerID.
+  final String? keyFile;
+
+  /// Passed as `--key-type` to long-running peers and scenarios: `ed25519`,
+  /// `rsa`, `secp256k1` or `ecdsa`.
+  final String? keyType;
+
+  /// Extra PeerConfig YAML (e.g. a `resource_manager:` section) written to
+  /// the `--config` file of long-running peers. Must not contain `yamux:`
+  /// when the yamux parameters of [startServer] or [yamuxConfig] are used;
+  /// starting the peer throws an [ArgumentError] if it does.
+  final String? configYaml;
+
+  /// Extra `yamux:` settings for long-running peers, keyed by their YAML
+  /// names, e.g. `{'max_stream_window': 262144, 'stream_open_timeout': '2s'}`.
+  final Map<String, Object>? yamuxConfig;
+
+  /// Passed as `--trace-yamux` to long-running peers: a file that receives
+  /// every yamux frame (JSON lines for `.json`/`.jsonl`), or `-` for
+  /// `yamux_frame` events in [events].
+  final String? traceYamux;
+
+  /// Passed as `--psk-file` to every Go peer this manager runs, so they only
+  /// talk to peers of that private network.
+  final String? pskFile;
+
+  /// Passed as `--psk-mismatch`: the peers invert the [pskFile] key, so every
+  /// handshake with a correctly keyed peer fails.
+  final bool pskMismatch;
+
+  /// Passed as `--security` to every Go peer this manager runs: `noise`,
+  /// `tls`, or both in order of preference (`tls,noise`).
+  final String? security;
+
+  /// Passed as `--early-muxer`: whether Go peers offer their muxers inside
+  /// the Noise/TLS handshake. Null keeps go-libp2p's default (on).
+  final bool? earlyMuxer;
+
+  /// Passed as `--muxers`: muxer IDs the Go peers offer, in order of
+  /// preference. Only `/yamux/` IDs are accepted, all served by yamux.
+  final List<String>? muxers;
+
+  /// Passed as `--ping-delay` and `--ping-jitter`: Go peers that serve ping
+  /// hold every pong for [pingDelay] plus a random extra up to [pingJitter].
+  final Duration? pingDelay;
+  final Duration? pingJitter;
   Process? _process;
   PeerId? _peerId;
   MultiAddr? _listenAddr;
+  final List<MultiAddr> _listenAddrs = [];
   String? _circuitAddr;
+  String? _certFingerprint;
   final List<String> _output = [];
   final _outputController = StreamController<String>.broadcast();
+  final List<Map<String, dynamic>> _events = [];
+  final _eventController = StreamController<Map<String, dynamic>>.broadcast();
   bool _ready = false;
   File? _configFile;
-
-  GoProcessManager({required this.binaryPath});
+  int _nextCommandId = 0;
+
+  GoProcessManager({
+    required this.binaryPath,
+    this.jsonOutput = false,
+    this.seed,
+    this.keyFile,
+    this.keyType,
+    this.configYaml,
+    this.yamuxConfig,
+    this.traceYamux,
+    this.pskFile,
+    this.pskMismatch = false,
+    this.security,
+    this.earlyMuxer,
+    this.muxers,
+    this.pingDelay,
+    this.pingJitter,
+  });
+
+  /// Flags every Go peer gets, long-running or not.
+  List<String> get _peerArgs => [
+        if (pskFile != null) '--psk-file=$pskFile',
+        if (pskMismatch) '--psk-mismatch',
+        if (security != null) '--security=$security',
+        if (earlyMuxer != null) '--early-muxer=$earlyMuxer',
+        if (muxers != null) '--muxers=${muxers!.join(',')}',
+        if (pingDelay != null) '--ping-delay=${pingDelay!.inMilliseconds}ms',
+        if (pingJitter != null) '--ping-jitter=${pingJitter!.inMilliseconds}ms',
+      ];
+
+  /// Identity flags of peers that stand for this manager's PeerID: the
+  /// long-running peer and scenarios. Short-lived clients keep random keys so
+  /// they never collide with the server they dial.
+  List<String> get _identityArgs => [
+        if (seed != null) '--seed=$seed',
+        if (keyFile != null) '--key-file=$keyFile',
+        if (keyType != null) '--key-type=$keyType',
+      ];
 
   PeerId get peerId {
     if (_peerId == null) throw StateError('Go peer not started');
@@ -29,13 +127,25 @@ class GoProcessManager {
     return _listenAddr!;
   }
 
+  /// Every address the peer printed, across all transports and IP versions.
+  List<MultiAddr> get listenAddrs => List.unmodifiable(_listenAddrs);
+
   String get circuitAddr {
     if (_circuitAddr == null) throw StateError('No circuit address available');
     return _circuitAddr!;
   }
 
+  /// SHA-256 fingerprint of the self-signed certificate of a `wss` peer.
+  String get certFingerprint {
+    if (_certFingerprint == null) throw StateError('No certificate fingerprint available');
+    return _certFingerprint!;
+  }
+
   List<String> get output => List.unmodifiable(_output);
 
+  /// Events decoded so far when [jsonOutput] is enabled.
+  List<Map<String, dynamic>> get events => List.unmodifiable(_events);
+
   /// Builds the Go peer binary if it doesn't exist.
   static Future<String> ensureBinary(String goSourceDir) async {
     final binaryPath = '$goSourceDir/go-peer';
@@ -86,21 +196,36 @@ class GoProcessManager {
     ]);
   }
 
-  /// Writes a temp YAML config file if yamux params are provided.
-  /// Returns the `--config=<path>` arg string, or null if no config needed.
+  /// Writes a temp YAML config file if yamux params or [configYaml] are
+  /// provided. Returns the `--config=<path>` arg string, or null if no config
+  /// needed.
   Future<String?> _writeConfigIfNeeded({
     Duration? yamuxKeepAliveInterval,
     Duration? yamuxWriteTimeout,
   }) async {
-    if (yamuxKeepAliveInterval == null && yamuxWriteTimeout == null) return null;
+    final hasYamux = yamuxKeepAliveInterval != null ||
+        yamuxWriteTimeout != null ||
+        yamuxConfig != null;
+    if (!hasYamux && configYaml == null) return null;
+    // The yamux settings are appended as their own section; a second
+    // top-level `yamux:` key would make the config file invalid.
+    if (hasYamux &&
+        configYaml != null &&
+        RegExp(r'^yamux\s*:', multiLine: true).hasMatch(configYaml!)) {
+      throw ArgumentError.value(configYaml, 'configYaml',
+          'must not contain a yamux: section when yamux parameters or yamuxConfig are used');
+    }
 
-    final buf = StringBuffer('yamux:\n');
+    final buf = StringBuffer();
+    if (configYaml != null) buf.writeln(configYaml);
+    if (hasYamux) buf.writeln('yamux:');
     if (yamuxKeepAliveInterval != null) {
       buf.writeln('  keepalive_interval: ${yamuxKeepAliveInterval.inSeconds}');
     }
     if (yamuxWriteTimeout != null) {
       buf.writeln('  connection_write_timeout: ${yamuxWriteTimeout.inSeconds}');
     }
+    yamuxConfig?.forEach((key, value) => buf.writeln('  $key: $value'));
 
     final tempDir = await Directory.systemTemp.createTemp('go_peer_config_');
     _configFile = File('${tempDir.path}/config.yaml');
@@ -109,7 +234,17 @@ class GoProcessManager {
   }
 
   Future<void> _start(List<String> args) async {
-    _process = await Process.start(binaryPath, args);
+    final configArg = args.any((a) => a.startsWith('--config='))
+        ? null
+        : await _writeConfigIfNeeded();
+    _process = await Process.start(binaryPath, [
+      ...args,
+      if (configArg != null) configArg,
+      if (jsonOutput) '--output=json',
+      if (traceYamux != null) '--trace-yamux=$traceYamux',
+      ..._identityArgs,
+      ..._peerArgs,
+    ]);
 
     _process!.stderr.transform(utf8.decoder).transform(const LineSplitter()).listen((line) {
       _output.add('[stderr] $line');
@@ -120,34 +255,79 @@ class GoProcessManager {
       _output.add(line);
       _outputController.add(line);
 
-      if (line.startsWith('PeerID: ')) {
+      if (jsonOutput && line.startsWith('{')) {
+        _handleEvent(jsonDecode(line) as Map<String, dynamic>);
+      } else if (line.startsWith('PeerID: ')) {
         _peerId = PeerId.fromString(line.substring('PeerID: '.length).trim());
-      } else if (line.startsWith('Listening: ') && line.contains('127.0.0.1')) {
-        _listenAddr = MultiAddr(line.substring('Listening: '.length).trim());
+      } else if (line.startsWith('Listening: ')) {
+        final addr = MultiAddr(line.substring('Listening: '.length).trim());
+        _listenAddrs.add(addr);
+        if (line.contains('127.0.0.1')) _listenAddr = addr;
       } else if (line.startsWith('CircuitAddr: ')) {
         _circuitAddr = line.substring('CircuitAddr: '.length).trim();
+      } else if (line.startsWith('CertFingerprint: ')) {
+        _certFingerprint = line.substring('CertFingerprint: '.length).trim();
       } else if (line == 'Ready') {
         _ready = true;
       }
     });
 
     // Wait for the process to be ready
-    await waitForOutput('Ready', timeout: const Duration(seconds: 30));
+    if (jsonOutput) {
+      await waitForEvent('ready', timeout: const Duration(seconds: 30));
+    } else {
+      await waitForOutput('Ready', timeout: const Duration(seconds: 30));
+    }
+  }
+
+  void _handleEvent(Map<String, dynamic> event) {
+    _events.add(event);
+    _eventController.add(event);
+
+    final addrs = (event['addrs'] as List<dynamic>? ?? const []).cast<String>();
+    switch (event['type']) {
+      case 'peer_id':
+        _peerId = PeerId.fromString(event['peer'] as String);
+      case 'listening':
+        for (final addr in addrs) {
+          _listenAddrs.add(MultiAddr(addr));
+          if (addr.contains('127.0.0.1')) _listenAddr = MultiAddr(addr);
+        }
+      case 'circuit_addr':
+        if (addrs.isNotEmpty) _circuitAddr = addrs.first;
+      case 'certificate':
+        _certFingerprint = event['message'] as String?;
+      case 'ready':
+        _ready = true;
+    }
   }
 
   /// Runs the Go peer in client mode (connects and exits).
   Future<ProcessResult> runClient(String targetMultiaddr, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=client', '--target=$targetMultiaddr', '--transport=$transport'],
+      [..._peerArgs, '--mode=client', '--target=$targetMultiaddr', '--transport=$transport'],
     );
   }
 
-  /// Runs the Go peer in ping mode.
-  Future<ProcessResult> runPing(String targetMultiaddr, {String transport = 'tcp'}) async {
+  /// Runs the Go peer in ping mode: [count] pings [interval] apart, each on
+  /// a fresh stream unless [reuseStream] is set. With more than one ping the
+  /// output ends with a `PingStats:` line.
+  Future<ProcessResult> runPing(
+    String targetMultiaddr, {
+    String transport = 'tcp',
+    int count = 1,
+    Duration interval = const Duration(seconds: 1),
+    bool reuseStream = false,
+    Duration pingTimeout = const Duration(seconds: 10),
+  }) async {
     return Process.run(
       binaryPath,
-      ['--mode=ping', '--target=$targetMultiaddr', '--transport=$transport'],
+      [
+        ..._peerArgs, '--mode=ping', '--target=$targetMultiaddr', '--transport=$transport',
+        '--count=$count', '--interval=${interval.inMilliseconds}ms', if (reuseStream) '--reuse-stream',
+        '--ping-timeout=${pingTimeout.inMilliseconds}ms',
+      ],
     );
   }
 
@@ -157,36 +337,36 @@ class GoProcessManager {
   Future<ProcessResult> runPushTest(String targetMultiaddr, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=push-test', '--target=$targetMultiaddr', '--transport=$transport'],
+      [..._peerArgs, '--mode=push-test', '--target=$targetMultiaddr', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Starts the Go peer in relay mode (circuit relay v2 service).
-  Future<void> startRelay({int port = 0}) async {
-    await _start(['--mode=relay', '--port=$port']);
+  Future<void> startRelay({int port = 0, String transport = 'tcp'}) async {
+    await _start(['--mode=relay', '--port=$port', '--transport=$transport']);
   }
 
   /// Starts the Go peer in relay-echo-server mode.
   /// Connects to the relay and reserves a slot, then handles echo streams.
-  Future<void> startRelayEchoServer(String relayAddr) async {
-    await _start(['--mode=relay-echo-server', '--relay=$relayAddr']);
+  Future<void> startRelayEchoServer(String relayAddr, {String transport = 'tcp'}) async {
+    await _start(['--mode=relay-echo-server', '--relay=$relayAddr', '--transport=$transport']);
   }
 
   /// Runs the Go peer in relay-echo-client mode.
-  Future<ProcessResult> runRelayEchoClient(String circuitAddr, String message) async {
+  Future<ProcessResult> runRelayEchoClient(String circuitAddr, String message, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=relay-echo-client', '--target=$circuitAddr', '--message=$message'],
+      [..._peerArgs, '--mode=relay-echo-client', '--target=$circuitAddr', '--message=$message', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Starts the Go peer in dht-server mode.
-  Future<void> startDHTServer({int port = 0}) async {
-    await _start(['--mode=dht-server', '--port=$port']);
+  Future<void> startDHTServer({int port = 0, String transport = 'tcp'}) async {
+    await _start(['--mode=dht-server', '--port=$port', '--transport=$transport']);
   }
 
   /// Starts the Go peer in dht-relay-server mode (DHT server + relay service).
@@ -206,75 +386,91 @@ class GoProcessManager {
   }
 
   /// Runs the Go peer in dht-put-value mode.
-  Future<ProcessResult> runDHTPutValue(String target, String key, String value) async {
+  Future<ProcessResult> runDHTPutValue(String target, String key, String value, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-put-value', '--target=$target', '--key=$key', '--value=$value'],
+      [..._peerArgs, '--mode=dht-put-value', '--target=$target', '--key=$key', '--value=$value', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Runs the Go peer in dht-put-value mode with --pk-self (stores own public key).
-  Future<ProcessResult> runDHTPutPkSelf(String target) async {
+  Future<ProcessResult> runDHTPutPkSelf(String target, {String? keyType, String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-put-value', '--target=$target', '--pk-self'],
+      [
+        ..._peerArgs, '--mode=dht-put-value', '--target=$target', '--pk-self', '--transport=$transport',
+        if (keyType != null) '--key-type=$keyType',
+      ],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Runs the Go peer in dht-get-value mode.
-  Future<ProcessResult> runDHTGetValue(String target, String key) async {
+  Future<ProcessResult> runDHTGetValue(String target, String key, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-get-value', '--target=$target', '--key=$key'],
+      [..._peerArgs, '--mode=dht-get-value', '--target=$target', '--key=$key', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Runs the Go peer in dht-get-value mode with --pk-peer (gets /pk/ record for a peer).
-  Future<ProcessResult> runDHTGetPkPeer(String target, String peerId) async {
+  Future<ProcessResult> runDHTGetPkPeer(String target, String peerId, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-get-value', '--target=$target', '--pk-peer=$peerId'],
+      [..._peerArgs, '--mode=dht-get-value', '--target=$target', '--pk-peer=$peerId', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Runs the Go peer in dht-provide mode.
-  Future<ProcessResult> runDHTProvide(String target, String cid) async {
+  Future<ProcessResult> runDHTProvide(String target, String cid, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-provide', '--target=$target', '--cid=$cid'],
+      [..._peerArgs, '--mode=dht-provide', '--target=$target', '--cid=$cid', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Runs the Go peer in dht-find-providers mode.
-  Future<ProcessResult> runDHTFindProviders(String target, String cid) async {
+  Future<ProcessResult> runDHTFindProviders(String target, String cid, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=dht-find-providers', '--target=$target', '--cid=$cid'],
+      [..._peerArgs, '--mode=dht-find-providers', '--target=$target', '--cid=$cid', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
   }
 
   /// Starts the Go peer in pubsub-server mode.
-  Future<void> startPubSubServer({int port = 0, String topic = 'test-topic'}) async {
-    await _start(['--mode=pubsub-server', '--port=$port', '--topic=$topic']);
+  Future<void> startPubSubServer({int port = 0, String topic = 'test-topic', String transport = 'tcp'}) async {
+    await _start(['--mode=pubsub-server', '--port=$port', '--topic=$topic', '--transport=$transport']);
+  }
+
+  /// Starts the Go peer with an arbitrary set of services mounted on one host
+  /// (`echo`, `ping`, `relay`, `dht`, `pubsub`, `autonat`, `holepunch`).
+  Future<void> startServices(
+    List<String> services, {
+    int port = 0,
+    String transport = 'tcp',
+    String topic = 'test-topic',
+  }) async {
+    await _start([
+      '--services=${services.join(',')}', '--port=$port', '--transport=$transport', '--topic=$topic',
+    ]);
   }
 
   /// Runs the Go peer in pubsub-client mode.
-  Future<ProcessResult> runPubSubClient(String targetMultiaddr, String topic, String message) async {
+  Future<ProcessResult> runPubSubClient(String targetMultiaddr, String topic, String message, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=pubsub-client', '--target=$targetMultiaddr', '--topic=$topic', '--message=$message'],
+      [..._peerArgs, '--mode=pubsub-client', '--target=$targetMultiaddr', '--topic=$topic', '--message=$message', '--transport=$transport'],
       stdoutEncoding: utf8,
       stderrEncoding: utf8,
     ).timeout(const Duration(seconds: 30));
@@ -284,10 +480,218 @@ class GoProcessManager {
   Future<ProcessResult> runEchoClient(String targetMultiaddr, String message, {String transport = 'tcp'}) async {
     return Process.run(
       binaryPath,
-      ['--mode=echo-client', '--target=$targetMultiaddr', '--message=$message', '--transport=$transport'],
+      [..._peerArgs, '--mode=echo-client', '--target=$targetMultiaddr', '--message=$message', '--transport=$transport'],
     );
   }
 
+  /// Starts the Go peer in close-behavior mode: an echo server that ends
+  /// every /echo/1.0.0 stream with [action] after [afterBytes] echoed bytes
+  /// (`reset`, `close-write`, `goaway-normal`, `goaway-protocol`,
+  /// `goaway-internal` or `drop`), then reports `CloseObserved:` lines about
+  /// the Dart side's reaction for [observe].
+  Future<void> startCloseBehaviorServer({
+    required String action,
+    int afterBytes = 0,
+    Duration observe = const Duration(seconds: 5),
+    int port = 0,
+    String transport = 'tcp',
+  }) async {
+    await _start([
+      '--mode=close-behavior', '--close-behavior=$action', '--after-bytes=$afterBytes',
+      '--observe=${observe.inMilliseconds}ms', '--port=$port', '--transport=$transport',
+    ]);
+  }
+
+  /// Changes the action of a running close-behavior server for the streams
+  /// opened from now on.
+  void setCloseBehavior(String action, {int afterBytes = 0}) {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('close-behavior $action $afterBytes');
+  }
+
+  /// Starts the Go peer as an echo server that reads each stream at most
+  /// [readRate] bytes per second (0 for unthrottled), stopping for [pause]
+  /// once [pauseAfter] bytes have been read.
+  Future<void> startSlowEchoServer({
+    int readRate = 0,
+    Duration pause = Duration.zero,
+    int pauseAfter = 0,
+    int port = 0,
+    String transport = 'tcp',
+  }) async {
+    await _start([
+      '--mode=slow-echo-server', '--read-rate=$readRate', '--pause=${pause.inMilliseconds}ms',
+      '--pause-after=$pauseAfter', '--port=$port', '--transport=$transport',
+    ]);
+  }
+
+  /// Makes a running slow echo server stop reading every stream for [pause].
+  void pauseReading(Duration pause) {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('pause ${pause.inMilliseconds}ms');
+  }
+
+  /// Changes the read rate of a running slow echo server; 0 removes the
+  /// throttle.
+  void setReadRate(int bytesPerSecond) {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('rate $bytesPerSecond');
+  }
+
+  /// Runs the Go peer in stream-stress mode: [streams] /echo/1.0.0 streams
+  /// over one connection, at most [concurrency] open at once (0 for all),
+  /// each echoing [payloads] random payloads of [payloadSize] bytes, and
+  /// returns the decoded `stress_result` event. The exit code is non-zero if
+  /// any stream failed.
+  Future<StreamStressResult> runStreamStress(
+    String targetMultiaddr, {
+    int streams = 100,
+    int concurrency = 0,
+    int payloadSize = 64 * 1024,
+    int payloads = 1,
+    String transport = 'tcp',
+    Duration timeout = const Duration(minutes: 2),
+  }) async {
+    final result = await Process.run(
+      binaryPath,
+      [
+        ..._peerArgs, '--mode=stream-stress', '--target=$targetMultiaddr', '--streams=$streams',
+        '--concurrency=$concurrency', '--payload-size=$payloadSize', '--payloads=$payloads',
+        '--transport=$transport', '--output=json',
+      ],
+      stdoutEncoding: utf8,
+      stderrEncoding: utf8,
+    ).timeout(timeout);
+    final event = _lastEvent(result, 'stress_result');
+    return StreamStressResult(
+        result.exitCode, event['stress'] as Map<String, dynamic>, result.stderr as String);
+  }
+
+  /// Starts the Go peer as a /perf/1.0.0 server.
+  Future<void> startPerfServer({int port = 0, String transport = 'tcp'}) async {
+    await _start(['--mode=perf-server', '--port=$port', '--transport=$transport']);
+  }
+
+  /// Runs the Go peer in perf-client mode: upload [uploadBytes], then download
+  /// [downloadBytes] on one /perf/1.0.0 stream, and return the decoded
+  /// `perf_result` event.
+  Future<PerfResult> runPerfClient(
+    String targetMultiaddr, {
+    int uploadBytes = 100 << 20,
+    int downloadBytes = 100 << 20,
+    String transport = 'tcp',
+    Duration timeout = const Duration(minutes: 2),
+  }) async {
+    final result = await Process.run(
+      binaryPath,
+      [
+        ..._peerArgs, '--mode=perf-client', '--target=$targetMultiaddr', '--upload-bytes=$uploadBytes',
+        '--download-bytes=$downloadBytes', '--transport=$transport', '--output=json',
+      ],
+      stdoutEncoding: utf8,
+      stderrEncoding: utf8,
+    ).timeout(timeout);
+    final event = _lastEvent(result, 'perf_result');
+    return PerfResult(result.exitCode, event['perf'] as Map<String, dynamic>, result.stderr as String);
+  }
+
+  /// Starts the Go peer in daemon mode. The running peer is then driven with
+  /// [command].
+  Future<void> startDaemon({int port = 0, String transport = 'tcp'}) async {
+    await _start(['--mode=daemon', '--port=$port', '--transport=$transport']);
+  }
+
+  /// Sends a JSON command to a Go peer started with [startDaemon] and returns
+  /// the decoded reply (`ok`, `error`, `result`).
+  Future<Map<String, dynamic>> command(
+    String cmd, {
+    Map<String, dynamic> args = const {},
+    Duration timeout = const Duration(seconds: 30),
+  }) async {
+    if (_process == null) throw StateError('Go peer not started');
+    final id = ++_nextCommandId;
+    final reply = _outputController.stream
+        .where((line) => line.startsWith('{'))
+        .map((line) => jsonDecode(line) as Map<String, dynamic>)
+        .firstWhere((msg) => msg['type'] == 'reply' && msg['id'] == id)
+        .timeout(timeout);
+    _process!.stdin.writeln(jsonEncode({'id': id, 'cmd': cmd, ...args}));
+    return reply;
+  }
+
+  /// Starts the Go peer in p2pd mode, serving the go-libp2p-daemon control
+  /// protocol on [socketPath].
+  Future<void> startP2pd({
+    required String socketPath,
+    int port = 0,
+    String transport = 'tcp',
+  }) async {
+    await _start([
+      '--mode=p2pd', '--socket=$socketPath', '--port=$port', '--transport=$transport',
+    ]);
+  }
+
+  /// Runs a YAML scenario file against [targetMultiaddr] and returns the
+  /// decoded `scenario_report` line (`passed`, `steps`).
+  Future<Map<String, dynamic>> runScenario(
+    String scenarioPath, {
+    String? targetMultiaddr,
+    Duration timeout = const Duration(seconds: 120),
+  }) async {
+    final result = await Process.run(
+      binaryPath,
+      [
+        '--scenario=$scenarioPath',
+        ..._identityArgs,
+        ..._peerArgs,
+        if (targetMultiaddr != null) '--target=$targetMultiaddr',
+      ],
+      stdoutEncoding: utf8,
+      stderrEncoding: utf8,
+    ).timeout(timeout);
+    final reportLine = const LineSplitter()
+        .convert(result.stdout as String)
+        .lastWhere((line) => line.contains('"scenario_report"'), orElse: () {
+      throw StateError(
+          'Scenario produced no report (exit ${result.exitCode}):\n${result.stderr}');
+    });
+    return jsonDecode(reportLine) as Map<String, dynamic>;
+  }
+
+  /// Decodes the last [type] event a `--output=json` run printed.
+  static Map<String, dynamic> _lastEvent(ProcessResult result, String type) {
+    final line = const LineSplitter()
+        .convert(result.stdout as String)
+        .lastWhere((line) => line.contains('"$type"'), orElse: () {
+      throw StateError(
+          'Go peer reported no $type (exit ${result.exitCode}):\n${result.stderr}');
+    });
+    return jsonDecode(line) as Map<String, dynamic>;
+  }
+
+  /// Waits for an event of [type] (optionally matching [where]) when
+  /// [jsonOutput] is enabled.
+  Future<Map<String, dynamic>> waitForEvent(
+    String type, {
+    bool Function(Map<String, dynamic> event)? where,
+    Duration timeout = const Duration(seconds: 30),
+  }) async {
+    bool matches(Map<String, dynamic> e) =>
+        e['type'] == type && (where == null || where(e));
+
+    for (final event in _events) {
+      if (matches(event)) return event;
+    }
+
+    return _eventController.stream.firstWhere(matches).timeout(timeout,
+        onTimeout: () {
+      throw TimeoutException(
+        'Timed out waiting for "$type" event from Go peer.\nOutput so far:\n${_output.join("\n")}',
+        timeout,
+      );
+    });
+  }
+
   /// Waits for a specific string to appear in the output.
   Future<String> waitForOutput(String pattern, {Duration timeout = const Duration(seconds: 30)}) async {
     // Check existing output first
@@ -305,6 +709,34 @@ class GoProcessManager {
     });
   }
 
+  /// Asks a running Go peer to trim its connections now. The outcome is
+  /// reported as `TrimClosed:` lines and a `Trim (manual):` summary.
+  void trim() {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('trim');
+  }
+
+  /// Asks a running Go peer to close all its connections to [peerId].
+  void closePeer(String peerId) {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('close-peer $peerId');
+  }
+
+  /// Changes the connection gater rules of a running Go peer, e.g.
+  /// `gater('deny-peer', peerId)` or `gater('stage', 'upgraded')`. Each change
+  /// is confirmed by a `GaterUpdated:` line.
+  void gater(String command, [String? arg]) {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln(['gater', command, if (arg != null) arg].join(' '));
+  }
+
+  /// Asks a running Go peer to print `YamuxStats:` for every open yamux
+  /// session. Needs `yamuxConfig: {'stats': true}`.
+  void requestYamuxStats() {
+    if (_process == null) throw StateError('Go peer not started');
+    _process!.stdin.writeln('yamux-stats');
+  }
+
   /// Stops the Go peer process.
   Future<void> stop() async {
     if (_process != null) {
@@ -327,9 +759,12 @@ class GoProcessManager {
     }
     _peerId = null;
     _listenAddr = null;
+    _listenAddrs.clear();
     _circuitAddr = null;
+    _certFingerprint = null;
     _ready = false;
     _output.clear();
+    _events.clear();
 
     if (_configFile != null) {
       try {
@@ -341,3 +776,56 @@ class GoProcessManager {
     }
   }
 }
+
+/// The outcome of [GoProcessManager.runStreamStress]: the exit code and the
+/// `stress` object of its `stress_result` event. Latencies run from opening
+/// a stream to reading its last echoed byte, over the streams that
+/// succeeded.
+class StreamStressResult {
+  StreamStressResult(this.exitCode, this.stats, this.stderr);
+
+  final int exitCode;
+
+  /// The decoded `stress` object, as the Go peer reported it.
+  final Map<String, dynamic> stats;
+
+  final String stderr;
+
+  int get streams => stats['streams'] as int;
+  int get failed => stats['failed'] as int;
+  int get peakOpen => stats['peak_open'] as int;
+  int get bytesEchoed => stats['bytes_echoed'] as int;
+  double get minMs => _double(stats['min_ms']);
+  double get p50Ms => _double(stats['p50_ms']);
+  double get p90Ms => _double(stats['p90_ms']);
+  double get p99Ms => _double(stats['p99_ms']);
+  double get maxMs => _double(stats['max_ms']);
+  double get elapsedMs => _double(stats['elapsed_ms']);
+}
+
+/// The outcome of [GoProcessManager.runPerfClient]: the exit code and the
+/// `perf` object of its `perf_result` event. Throughput is in Mbit/s.
+class PerfResult {
+  PerfResult(this.exitCode, this.stats, this.stderr);
+
+  final int exitCode;
+
+  /// The decoded `perf` object, as the Go peer reported it.
+  final Map<String, dynamic> stats;
+
+  final String stderr;
+
+  double get connectMs => _double(stats['connect_ms']);
+  double get setupMs => _double(stats['setup_ms']);
+  int get uploadBytes => stats['upload_bytes'] as int;
+  double get uploadMs => _double(stats['upload_ms']);
+  double get uploadMbps => _double(stats['upload_mbps']);
+  int get downloadBytes => stats['download_bytes'] as int;
+  double get downloadMs => _double(stats['download_ms']);
+  double get downloadMbps => _double(stats['download_mbps']);
+  double get ttfbMs => _double(stats['ttfb_ms']);
+  double get totalMs => _double(stats['total_ms']);
+}
+
+// Go encodes whole floats without a fraction, which decode as int.
+double _double(Object? v) => (v as num).toDouble();